@@ -27,6 +27,12 @@ func main() {
 	util.InitNotionClient()
 
 	util.InitLLM()
+
+	util.InitMemory()
+	util.InitHistory()
+	util.InitFetcher()
+	util.InitScraper()
+	util.InitIndex()
 	
 	// Initialize Slack client and Socket Mode
 	if err := util.InitializeSlackClient(); err != nil {
@@ -0,0 +1,92 @@
+package util
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+)
+
+// arxivAPIURL is the arXiv Atom API endpoint, a variable so it can point at a
+// mirror.
+var arxivAPIURL = "https://export.arxiv.org/api/query"
+
+// arxivFeed is the subset of the arXiv Atom API response BotBot uses.
+type arxivFeed struct {
+	Entries []arxivEntry `xml:"http://www.w3.org/2005/Atom entry"`
+}
+
+type arxivEntry struct {
+	ID        string `xml:"http://www.w3.org/2005/Atom id"`
+	Title     string `xml:"http://www.w3.org/2005/Atom title"`
+	Summary   string `xml:"http://www.w3.org/2005/Atom summary"`
+	Published string `xml:"http://www.w3.org/2005/Atom published"`
+	Updated   string `xml:"http://www.w3.org/2005/Atom updated"`
+	Authors   []struct {
+		Name string `xml:"http://www.w3.org/2005/Atom name"`
+	} `xml:"http://www.w3.org/2005/Atom author"`
+	DOI             string `xml:"http://arxiv.org/schemas/atom doi"`
+	PrimaryCategory struct {
+		Term string `xml:"term,attr"`
+	} `xml:"http://arxiv.org/schemas/atom primary_category"`
+}
+
+// arxivID returns the arXiv identifier, without version, of an abstract, PDF
+// or HTML link.
+func arxivID(pageURL *url.URL) (string, bool) {
+	if pageURL == nil {
+		return "", false
+	}
+	m := arxivPathRe.FindStringSubmatch(pageURL.Path)
+	if m == nil {
+		return "", false
+	}
+	return m[1], true
+}
+
+// Fetch gets the paper's metadata from the arXiv Atom API instead of
+// scraping the abstract page.
+func (arxivExtractor) Fetch(pageURL *url.URL) (Document, error) {
+	id, ok := arxivID(pageURL)
+	if !ok {
+		return Document{}, fmt.Errorf("no arXiv ID in %s", pageURL)
+	}
+
+	resp, err := fetchClient.Get(arxivAPIURL + "?id_list=" + url.QueryEscape(id))
+	if err != nil {
+		return Document{}, fmt.Errorf("error querying arXiv API: %w", err)
+	}
+	return parseArxivFeed(bytes.NewReader(resp.Body))
+}
+
+// parseArxivFeed reads the first paper of an arXiv Atom API response.
+func parseArxivFeed(r io.Reader) (Document, error) {
+	var feed arxivFeed
+	if err := xml.NewDecoder(r).Decode(&feed); err != nil {
+		return Document{}, fmt.Errorf("error parsing arXiv response: %w", err)
+	}
+	// Unknown IDs come back as a single entry titled "Error"
+	if len(feed.Entries) == 0 || feed.Entries[0].Title == "" || feed.Entries[0].Title == "Error" {
+		return Document{}, fmt.Errorf("paper not found on arXiv")
+	}
+
+	entry := feed.Entries[0]
+	authors := make([]string, 0, len(entry.Authors))
+	for _, author := range entry.Authors {
+		if name := cleanText(author.Name); name != "" {
+			authors = append(authors, name)
+		}
+	}
+
+	return Document{
+		Title:    cleanText(entry.Title),
+		Authors:  authors,
+		Date:     strings.TrimSpace(entry.Published),
+		Updated:  strings.TrimSpace(entry.Updated),
+		Category: strings.TrimSpace(entry.PrimaryCategory.Term),
+		DOI:      strings.TrimSpace(entry.DOI),
+		Abstract: cleanText(entry.Summary),
+	}, nil
+}
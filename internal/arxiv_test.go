@@ -0,0 +1,115 @@
+package util
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseArxivFeed(t *testing.T) {
+	tests := []struct {
+		file string
+		want Document
+	}{
+		{
+			file: "arxiv-paper.xml",
+			want: Document{
+				Title:    "Attention Is All You Need",
+				Authors:  []string{"Ashish Vaswani", "Noam Shazeer", "Niki Parmar", "Jakob Uszkoreit"},
+				Date:     "2017-06-12T17:57:34Z",
+				Updated:  "2023-08-02T00:41:18Z",
+				Category: "cs.CL",
+				Abstract: "The dominant sequence transduction models are based on complex recurrent or convolutional neural networks in an encoder-decoder configuration. The best performing models also connect the encoder and decoder through an attention mechanism. We propose a new simple network architecture, the Transformer, based solely on attention mechanisms, dispensing with recurrence and convolutions entirely.",
+			},
+		},
+		{
+			file: "arxiv-doi.xml",
+			want: Document{
+				Title:    "Observation of a new particle in the search for the Standard Model Higgs boson with the ATLAS detector at the LHC",
+				Authors:  []string{"The ATLAS Collaboration"},
+				Date:     "2012-07-31T17:59:57Z",
+				Updated:  "2012-08-31T16:28:53Z",
+				Category: "hep-ex",
+				DOI:      "10.1016/j.physletb.2012.08.020",
+				Abstract: "A search for the Standard Model Higgs boson in proton-proton collisions with the ATLAS detector at the LHC is presented. An excess of events is observed near a mass of 126 GeV, providing evidence for the production of a neutral boson.",
+			},
+		},
+		{
+			file: "arxiv-old-id.xml",
+			want: Document{
+				Title:    "The entropy formula for the Ricci flow and its geometric applications",
+				Authors:  []string{"Grisha Perelman"},
+				Date:     "2002-11-11T16:11:49Z",
+				Updated:  "2002-11-11T16:11:49Z",
+				Category: "math.DG",
+				Abstract: "We present a monotonic expression for the Ricci flow, valid in all dimensions and without curvature assumptions. It is interpreted as an entropy for a certain canonical ensemble. Several geometric applications are given.",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			f, err := os.Open(filepath.Join("testdata", tt.file))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+
+			got, err := parseArxivFeed(f)
+			if err != nil {
+				t.Fatalf("parseArxivFeed: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseArxivFeed() =\n%#v\nwant\n%#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseArxivFeedNotFound(t *testing.T) {
+	f, err := os.Open(filepath.Join("testdata", "arxiv-error.xml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := parseArxivFeed(f); err == nil {
+		t.Error("parseArxivFeed accepted the error entry")
+	}
+
+	empty := `<feed xmlns="http://www.w3.org/2005/Atom"><title>ArXiv Query</title></feed>`
+	if _, err := parseArxivFeed(strings.NewReader(empty)); err == nil {
+		t.Error("parseArxivFeed accepted a feed without entries")
+	}
+	if _, err := parseArxivFeed(strings.NewReader("<html>Service unavailable")); err == nil {
+		t.Error("parseArxivFeed accepted a non-XML response")
+	}
+}
+
+func TestArxivFetch(t *testing.T) {
+	var gotID string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotID = r.URL.Query().Get("id_list")
+		http.ServeFile(w, r, filepath.Join("testdata", "arxiv-old-id.xml"))
+	}))
+	defer server.Close()
+
+	defer func(prev string) { arxivAPIURL = prev }(arxivAPIURL)
+	arxivAPIURL = server.URL
+
+	pageURL, _ := url.Parse("https://arxiv.org/pdf/math/0211159v1")
+	doc, err := arxivExtractor{}.Fetch(pageURL)
+	if err != nil {
+		t.Fatalf("Fetch: %v", err)
+	}
+	if gotID != "math/0211159" {
+		t.Errorf("queried id_list=%q, want %q", gotID, "math/0211159")
+	}
+	if doc.Title != "The entropy formula for the Ricci flow and its geometric applications" {
+		t.Errorf("Title = %q", doc.Title)
+	}
+}
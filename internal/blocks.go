@@ -0,0 +1,106 @@
+package util
+
+import (
+	"fmt"
+
+	"github.com/jomei/notionapi"
+)
+
+// entryBlocks lays out the body of a saved entry's page: the summary as a
+// TL;DR callout, the key takeaways, the original abstract, a bookmark for the
+// link and a footer saying who saved it from where in Slack.
+func entryBlocks(doc Document, link string, summary Summary, origin Origin) []notionapi.Block {
+	blocks := []notionapi.Block{}
+
+	if summary.Text != "" {
+		tldr := notionapi.RichText{Text: &notionapi.Text{Content: "TL;DR "}, Annotations: &notionapi.Annotations{Bold: true}}
+		blocks = append(blocks, textBlocks(append([]notionapi.RichText{tldr}, richText(summary.Text)...), func(rt []notionapi.RichText) notionapi.Block {
+			return notionapi.CalloutBlock{
+				BasicBlock: basicBlock(notionapi.BlockCallout),
+				Callout: notionapi.Callout{
+					RichText: rt,
+					Icon:     &notionapi.Icon{Type: "emoji", Emoji: emoji("💡")},
+				},
+			}
+		})...)
+	}
+
+	if len(summary.Takeaways) > 0 {
+		blocks = append(blocks, notionapi.Heading3Block{
+			BasicBlock: basicBlock(notionapi.BlockTypeHeading3),
+			Heading3:   notionapi.Heading{RichText: richText("Key takeaways")},
+		})
+		for _, takeaway := range summary.Takeaways {
+			blocks = append(blocks, textBlocks(richText(takeaway), func(rt []notionapi.RichText) notionapi.Block {
+				return notionapi.BulletedListItemBlock{
+					BasicBlock:       basicBlock(notionapi.BlockTypeBulletedListItem),
+					BulletedListItem: notionapi.ListItem{RichText: rt},
+				}
+			})...)
+		}
+	}
+
+	if doc.Abstract != "" {
+		blocks = append(blocks, textBlocks(richText(doc.Abstract), func(rt []notionapi.RichText) notionapi.Block {
+			return notionapi.QuoteBlock{
+				BasicBlock: basicBlock(notionapi.BlockQuote),
+				Quote:      notionapi.Quote{RichText: rt},
+			}
+		})...)
+	}
+
+	if link != "" {
+		blocks = append(blocks, notionapi.BookmarkBlock{
+			BasicBlock: basicBlock(notionapi.BlockTypeBookmark),
+			Bookmark:   notionapi.Bookmark{URL: link},
+		})
+	}
+
+	if footer := originFooter(origin); len(footer) > 0 {
+		blocks = append(blocks,
+			notionapi.DividerBlock{BasicBlock: basicBlock(notionapi.BlockTypeDivider)},
+			notionapi.ParagraphBlock{
+				BasicBlock: basicBlock(notionapi.BlockTypeParagraph),
+				Paragraph:  notionapi.Paragraph{RichText: footer},
+			},
+		)
+	}
+	return blocks
+}
+
+// originFooter reads "Saved by @user from #channel", linking to the Slack
+// message when there is one.
+func originFooter(origin Origin) []notionapi.RichText {
+	if origin.User == "" {
+		return nil
+	}
+	user, channel, permalink := origin.Describe()
+
+	text := fmt.Sprintf("Saved by @%s", user)
+	if channel != "" {
+		text += " from " + channel
+	}
+	footer := []notionapi.RichText{{
+		Text:        &notionapi.Text{Content: text},
+		Annotations: &notionapi.Annotations{Italic: true, Color: notionapi.ColorGray},
+	}}
+	if permalink != "" {
+		footer = append(footer,
+			notionapi.RichText{Text: &notionapi.Text{Content: " · "}, Annotations: &notionapi.Annotations{Color: notionapi.ColorGray}},
+			notionapi.RichText{
+				Text:        &notionapi.Text{Content: "View in Slack", Link: &notionapi.Link{Url: permalink}},
+				Annotations: &notionapi.Annotations{Italic: true, Color: notionapi.ColorGray},
+			},
+		)
+	}
+	return footer
+}
+
+func basicBlock(t notionapi.BlockType) notionapi.BasicBlock {
+	return notionapi.BasicBlock{Object: notionapi.ObjectTypeBlock, Type: t}
+}
+
+func emoji(e string) *notionapi.Emoji {
+	value := notionapi.Emoji(e)
+	return &value
+}
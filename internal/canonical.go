@@ -0,0 +1,167 @@
+package util
+
+import (
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var (
+	// Query parameters that only track where a click came from.
+	trackingParams = map[string]bool{
+		"fbclid": true, "gclid": true, "dclid": true, "msclkid": true, "yclid": true,
+		"mc_cid": true, "mc_eid": true, "igshid": true, "_hsenc": true, "_hsmi": true,
+		"ref_src": true, "ref_url": true, "spm": true,
+	}
+
+	// arXiv abstract, PDF and HTML paths, for new (2301.01234) and old
+	// (hep-th/9901001) style IDs with an optional version.
+	arxivPathRe = regexp.MustCompile(`^/(?:abs|pdf|html)/([a-z\-]+(?:\.[A-Z]{2})?/\d{7}|\d{4}\.\d{4,5})(?:v\d+)?(?:\.pdf)?/?$`)
+
+	// AMP caches that embed the original host in the path.
+	ampCachePathRe = regexp.MustCompile(`^/(?:amp/|c/)?s/([^/]+)(/.*)?$`)
+
+	// Publishers known to serve AMP versions of their articles under /amp,
+	// .amp or ?amp. Elsewhere those spellings may be part of a real path, like
+	// github.com/ampproject/amp, so they are only stripped for these hosts,
+	// amp.* subdomains and pages unwrapped from an AMP cache.
+	ampPublishers = map[string]bool{
+		"bbc.com": true, "bbc.co.uk": true, "theguardian.com": true,
+		"washingtonpost.com": true, "cnn.com": true, "usatoday.com": true,
+		"independent.co.uk": true, "nbcnews.com": true, "cnbc.com": true,
+	}
+)
+
+// CanonicalURL maps the different spellings of a link to a single form so
+// the same document is only scraped and saved once. It upgrades http to
+// https, lowercases the host, drops "www.", fragments, default ports and
+// tracking parameters, sorts the query and rewrites known sites: arXiv
+// versions and PDFs become the abstract page, mobile Wikipedia the desktop
+// one, and AMP pages of known AMP publishers and caches the regular article.
+// Links that don't parse are returned unchanged.
+func CanonicalURL(raw string) string {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil || u.Host == "" {
+		return raw
+	}
+
+	if u.Scheme == "http" {
+		u.Scheme = "https"
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+	u.User = nil
+	u.Fragment = ""
+	u.RawFragment = ""
+
+	host := strings.ToLower(u.Hostname())
+	if port := u.Port(); port != "" && port != "80" && port != "443" {
+		host += ":" + port
+	}
+	u.Host = strings.TrimPrefix(host, "www.")
+
+	u, amp := unwrapAMPCache(u)
+	if strings.HasPrefix(u.Host, "amp.") {
+		u.Host = strings.TrimPrefix(u.Host, "amp.")
+		amp = true
+	}
+	amp = amp || ampPublishers[u.Host]
+	canonicalizeQuery(u, amp)
+
+	switch {
+	case u.Host == "arxiv.org" || u.Host == "export.arxiv.org":
+		if m := arxivPathRe.FindStringSubmatch(u.Path); m != nil {
+			return "https://arxiv.org/abs/" + m[1]
+		}
+		u.Host = "arxiv.org"
+	case strings.HasSuffix(u.Host, ".m.wikipedia.org"):
+		u.Host = strings.TrimSuffix(u.Host, ".m.wikipedia.org") + ".wikipedia.org"
+	case u.Host == "m.wikipedia.org":
+		u.Host = "wikipedia.org"
+	}
+
+	// Work on the escaped path so characters like "(" keep their spelling
+	path := u.EscapedPath()
+	if amp {
+		path = stripAMPPath(path)
+	}
+	if len(path) > 1 {
+		path = strings.TrimRight(path, "/")
+	}
+	if unescaped, err := url.PathUnescape(path); err == nil {
+		u.Path = unescaped
+		u.RawPath = path
+	}
+	return u.String()
+}
+
+// unwrapAMPCache turns Google and ampproject.org AMP cache links back into
+// the publisher's own URL. ok reports whether u was an AMP cache link.
+func unwrapAMPCache(u *url.URL) (_ *url.URL, ok bool) {
+	isGoogleAMP := (u.Host == "google.com" || strings.HasPrefix(u.Host, "google.")) && strings.HasPrefix(u.Path, "/amp/")
+	isAMPCache := strings.HasSuffix(u.Host, ".cdn.ampproject.org")
+	if !isGoogleAMP && !isAMPCache {
+		return u, false
+	}
+
+	m := ampCachePathRe.FindStringSubmatch(u.Path)
+	if m == nil {
+		return u, false
+	}
+	inner := &url.URL{
+		Scheme:   "https",
+		Host:     strings.TrimPrefix(strings.ToLower(m[1]), "www."),
+		Path:     m[2],
+		RawQuery: u.RawQuery,
+	}
+	return inner, true
+}
+
+// stripAMPPath removes the /amp suffix or prefix publishers use for AMP pages.
+func stripAMPPath(path string) string {
+	switch {
+	case strings.HasSuffix(path, "/amp") || strings.HasSuffix(path, "/amp/"):
+		path = strings.TrimSuffix(strings.TrimSuffix(path, "/"), "/amp")
+	case strings.HasPrefix(path, "/amp/"):
+		path = strings.TrimPrefix(path, "/amp")
+	case strings.HasSuffix(path, ".amp"):
+		path = strings.TrimSuffix(path, ".amp")
+	}
+	if path == "" {
+		path = "/"
+	}
+	return path
+}
+
+// canonicalizeQuery drops tracking parameters, and AMP ones for AMP pages,
+// and sorts the rest by name. The values of a repeated parameter keep their
+// order, which can matter to the site.
+func canonicalizeQuery(u *url.URL, amp bool) {
+	if u.RawQuery == "" {
+		u.ForceQuery = false
+		return
+	}
+
+	values := u.Query()
+	for key := range values {
+		lower := strings.ToLower(key)
+		if strings.HasPrefix(lower, "utm_") || trackingParams[lower] || (amp && (lower == "amp" || (lower == "outputtype" && values.Get(key) == "amp"))) {
+			values.Del(key)
+		}
+	}
+
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	parts := make([]string, 0, len(keys))
+	for _, key := range keys {
+		for _, v := range values[key] {
+			parts = append(parts, url.QueryEscape(key)+"="+url.QueryEscape(v))
+		}
+	}
+	u.RawQuery = strings.Join(parts, "&")
+	u.ForceQuery = false
+}
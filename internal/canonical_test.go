@@ -0,0 +1,67 @@
+package util
+
+import "testing"
+
+func TestCanonicalURL(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		// arXiv
+		{"arxiv abs", "https://arxiv.org/abs/2301.01234", "https://arxiv.org/abs/2301.01234"},
+		{"arxiv version", "https://arxiv.org/abs/2301.01234v3", "https://arxiv.org/abs/2301.01234"},
+		{"arxiv pdf", "https://arxiv.org/pdf/2301.01234", "https://arxiv.org/abs/2301.01234"},
+		{"arxiv pdf extension and version", "http://arxiv.org/pdf/2301.01234v2.pdf", "https://arxiv.org/abs/2301.01234"},
+		{"arxiv html", "https://arxiv.org/html/2301.01234v1", "https://arxiv.org/abs/2301.01234"},
+		{"arxiv five digit id", "https://www.arxiv.org/abs/2405.12345v1", "https://arxiv.org/abs/2405.12345"},
+		{"arxiv old style id", "https://arxiv.org/abs/hep-th/9901001v2", "https://arxiv.org/abs/hep-th/9901001"},
+		{"arxiv old style id with subject class", "https://arxiv.org/pdf/math.GT/0309136", "https://arxiv.org/abs/math.GT/0309136"},
+		{"arxiv export mirror", "https://export.arxiv.org/abs/2301.01234", "https://arxiv.org/abs/2301.01234"},
+		{"arxiv listing page", "https://arxiv.org/list/cs.LG/recent", "https://arxiv.org/list/cs.LG/recent"},
+
+		// Tracking parameters, scheme and host
+		{"utm parameters", "https://example.com/post?utm_source=x&utm_medium=y&UTM_Campaign=z", "https://example.com/post"},
+		{"utm mixed with real parameters", "https://example.com/search?q=go&utm_source=x&a=1", "https://example.com/search?a=1&q=go"},
+		{"repeated parameter keeps its order", "https://example.com/search?x=1&b=2&x=0", "https://example.com/search?b=2&x=1&x=0"},
+		{"click ids", "https://example.com/post?fbclid=abc&gclid=def", "https://example.com/post"},
+		{"http to https", "http://example.com/post", "https://example.com/post"},
+		{"www and case", "https://WWW.Example.COM/Post", "https://example.com/Post"},
+		{"default port", "https://example.com:443/post", "https://example.com/post"},
+		{"custom port kept", "https://example.com:8080/post", "https://example.com:8080/post"},
+		{"fragment", "https://example.com/post#section-2", "https://example.com/post"},
+		{"trailing slash", "https://example.com/post/", "https://example.com/post"},
+		{"escaped path kept", "https://en.wikipedia.org/wiki/Go_(programming_language)", "https://en.wikipedia.org/wiki/Go_(programming_language)"},
+
+		// Wikipedia
+		{"mobile wikipedia", "https://en.m.wikipedia.org/wiki/Transformer", "https://en.wikipedia.org/wiki/Transformer"},
+		{"mobile wikipedia other language", "http://de.m.wikipedia.org/wiki/Katze", "https://de.wikipedia.org/wiki/Katze"},
+
+		// AMP caches and publishers
+		{"google amp cache", "https://www.google.com/amp/s/www.bbc.com/news/article-123.amp", "https://bbc.com/news/article-123"},
+		{"google amp cache unknown publisher", "https://www.google.com/amp/s/blog.example.com/amp/post", "https://blog.example.com/post"},
+		{"ampproject cache", "https://www-example-com.cdn.ampproject.org/c/s/www.example.com/story/amp", "https://example.com/story"},
+		{"amp subdomain", "https://amp.theguardian.com/world/2024/story", "https://theguardian.com/world/2024/story"},
+		{"publisher amp suffix", "https://www.bbc.co.uk/news/uk-123.amp", "https://bbc.co.uk/news/uk-123"},
+		{"publisher amp path", "https://www.cnn.com/2024/01/01/tech/story/amp", "https://cnn.com/2024/01/01/tech/story"},
+		{"publisher amp query", "https://www.washingtonpost.com/tech/2024/story/?outputType=amp", "https://washingtonpost.com/tech/2024/story"},
+
+		// Paths that only look like AMP
+		{"amp repo", "https://github.com/ampproject/amp", "https://github.com/ampproject/amp"},
+		{"amp package", "https://www.npmjs.com/package/amp", "https://npmjs.com/package/amp"},
+		{"amp path prefix", "https://example.com/amp/story", "https://example.com/amp/story"},
+		{"amp extension", "https://example.com/files/song.amp", "https://example.com/files/song.amp"},
+		{"amp query on other hosts", "https://example.com/page?amp=1", "https://example.com/page?amp=1"},
+
+		// Unparsable links
+		{"no host", "not a url", "not a url"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := CanonicalURL(tt.in); got != tt.want {
+				t.Errorf("CanonicalURL(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,216 @@
+package util
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+const (
+	searchResultLimit = 5
+
+	// commandPrefix marks a mention or DM as a command, e.g. "!find rust".
+	commandPrefix = "!"
+)
+
+// commandFunc handles a built-in command. args is the text after the command
+// name and origin the message it came from.
+type commandFunc func(origin Origin, args string) string
+
+// commands are shared by @BotBot mentions, DMs and the /botbot slash command.
+var commands = map[string]commandFunc{
+	"ping":    pingCommand,
+	"help":    helpCommand,
+	"-h":      helpCommand,
+	"-help":   helpCommand,
+	"add":     addCommand,
+	"merge":   mergeCommand,
+	"search":  searchCommand,
+	"find":    findCommand,
+	"reindex": reindexCommand,
+}
+
+// RunCommand runs text as a built-in command. ok is false when the first word
+// is not a command and the text should go to the LLM instead.
+func RunCommand(origin Origin, text string) (response string, ok bool) {
+	name, args, _ := strings.Cut(strings.TrimSpace(text), " ")
+	cmd, ok := commands[strings.ToLower(name)]
+	if !ok {
+		return "", false
+	}
+	return cmd(origin, strings.TrimSpace(args)), true
+}
+
+// RunMessageCommand runs a mention or DM as a built-in command when it is
+// written as one, see messageCommand. ok is false for everything else, which
+// goes to the LLM.
+func RunMessageCommand(origin Origin, text string) (response string, ok bool) {
+	command, ok := messageCommand(text)
+	if !ok {
+		return "", false
+	}
+	return RunCommand(origin, command)
+}
+
+// chatPhrases start with "find" or "search" but are chat, not a lookup.
+var chatPhrases = []string{"find out", "find it", "find that", "find this", "find myself", "search me"}
+
+// messageCommand picks out the commands in a mention or DM. Chat often starts
+// with a command word ("ping me when...", "help me understand...", "find out
+// why..."), so only these forms count: "!<command> [args]", a command that
+// takes no arguments on its own ("ping", "help", "-h", "reindex"), "add" or
+// "merge" followed by a link, and "find" or "search" followed by a query that
+// doesn't start with one of the chatPhrases. It returns the text to give
+// RunCommand.
+func messageCommand(text string) (string, bool) {
+	text = strings.TrimSpace(text)
+	if command, ok := strings.CutPrefix(text, commandPrefix); ok {
+		return command, true
+	}
+
+	name, args, _ := strings.Cut(text, " ")
+	ok := false
+	switch strings.ToLower(name) {
+	case "ping", "help", "-h", "-help", "reindex":
+		ok = strings.TrimSpace(args) == ""
+	case "add", "merge":
+		intent, _ := ParseMessage(args)
+		ok = intent == IntentURL
+	case "find", "search":
+		ok = strings.TrimSpace(args) != "" && !isChatPhrase(text)
+	}
+	if !ok {
+		return "", false
+	}
+	return text, true
+}
+
+func isChatPhrase(text string) bool {
+	words := strings.ToLower(strings.Join(strings.Fields(text), " ")) + " "
+	for _, phrase := range chatPhrases {
+		if strings.HasPrefix(words, phrase+" ") {
+			return true
+		}
+	}
+	return false
+}
+
+func pingCommand(origin Origin, args string) string {
+	return fmt.Sprintf("Hello <@%s>! Pong!", origin.User)
+}
+
+func helpCommand(origin Origin, args string) string {
+	notionDBURL := os.Getenv("NOTION_DB_LINK")
+	return fmt.Sprintf("To add a link to notion follow the format:\n`@BotBot YOUR-URL-LINK-HERE LABEL1 LABEL2 ...`\n"+
+		"To save a PDF, Markdown or HTML file, attach it to a message `@BotBot LABEL1 LABEL2 ...`\n\n"+
+		"Commands, also available as `/botbot <command>`. In a message, start them with `!` when BotBot takes them for chat, e.g. `@BotBot !ping me`:\n"+
+		"`add <url> [labels...]` save a link to Notion\n"+
+		"`merge <url> <labels...>` add labels to a link that is already saved\n"+
+		"`search <words>` look up saved links by keyword\n"+
+		"`find <question>` look up saved links by meaning\n"+
+		"`reindex` rebuild the search index from Notion\n"+
+		"`help` show this message\n\nNotion Database URL:\n%s", notionDBURL)
+}
+
+func addCommand(origin Origin, args string) string {
+	intent, link := ParseMessage(args)
+	if intent != IntentURL {
+		return "Usage: `add <url> [labels...]`"
+	}
+	response, err := SaveLink(link, origin)
+	if err != nil {
+		return "Sorry, I couldn't save that link."
+	}
+	return response
+}
+
+func mergeCommand(origin Origin, args string) string {
+	intent, link := ParseMessage(args)
+	if intent != IntentURL || len(link.Labels) == 0 {
+		return "Usage: `merge <url> <labels...>`"
+	}
+
+	page, err := FindEntryByURL(CanonicalURL(link.URL), link.URL)
+	if err != nil {
+		log.Printf("Failed to look up link: %v", err)
+		return describeNotionError(err)
+	}
+	if page == nil {
+		return "That link isn't saved yet, use `add` instead."
+	}
+
+	page, err = AddLabelsToEntry(page, link.Labels)
+	if err != nil {
+		log.Printf("Failed to merge labels: %v", err)
+		return describeNotionError(err)
+	}
+	updateGlobalLabels(link.Labels)
+
+	entry := entryFromPage(*page)
+	if err := IndexEntry(string(page.ID), entry); err != nil {
+		log.Printf("Failed to index entry: %v", err)
+	}
+	return fmt.Sprintf("Updated <%s|%s>, labels are now: %s", entry.PageURL, entry.Title, strings.Join(entry.Labels, ", "))
+}
+
+func searchCommand(origin Origin, args string) string {
+	if args == "" {
+		return "Usage: `search <words>`"
+	}
+	entries, err := SearchEntries(args, searchResultLimit)
+	if err != nil {
+		log.Printf("Failed to search Notion: %v", err)
+		return describeNotionError(err)
+	}
+	if len(entries) == 0 {
+		return fmt.Sprintf("I couldn't find anything saved about \"%s\".", args)
+	}
+	return formatEntries(entries)
+}
+
+func findCommand(origin Origin, args string) string {
+	if args == "" {
+		return "Usage: `find <question>`"
+	}
+	if index.Len() == 0 {
+		return "The search index is empty, run `reindex` first."
+	}
+	entries, err := FindEntries(args, searchResultLimit)
+	if err != nil {
+		return "Sorry, I couldn't search the index right now."
+	}
+	if len(entries) == 0 {
+		return fmt.Sprintf("I couldn't find anything saved about \"%s\".", args)
+	}
+	return formatEntries(entries)
+}
+
+func reindexCommand(origin Origin, args string) string {
+	n, err := RebuildIndex()
+	if err != nil {
+		log.Printf("Failed to rebuild index: %v", err)
+		return "Sorry, I couldn't rebuild the search index."
+	}
+	return fmt.Sprintf("Re-indexed %d saved links.", n)
+}
+
+// formatEntries renders entries as a Slack bullet list linking to Notion.
+func formatEntries(entries []Entry) string {
+	var b strings.Builder
+	for _, entry := range entries {
+		title := entry.Title
+		if title == "" {
+			title = entry.URL
+		}
+		fmt.Fprintf(&b, "• <%s|%s>", entry.PageURL, title)
+		if len(entry.Labels) > 0 {
+			fmt.Fprintf(&b, " (%s)", strings.Join(entry.Labels, ", "))
+		}
+		if entry.URL != "" {
+			fmt.Fprintf(&b, " — %s", entry.URL)
+		}
+		b.WriteString("\n")
+	}
+	return strings.TrimSpace(b.String())
+}
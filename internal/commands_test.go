@@ -0,0 +1,50 @@
+package util
+
+import "testing"
+
+func TestMessageCommand(t *testing.T) {
+	tests := []struct {
+		text    string
+		command string
+		ok      bool
+	}{
+		{"ping", "ping", true},
+		{"  PING ", "PING", true},
+		{"-h", "-h", true},
+		{"help", "help", true},
+		{"reindex", "reindex", true},
+		{"add https://example.com/post ml", "add https://example.com/post ml", true},
+		{"merge <https://example.com/post> ml", "merge <https://example.com/post> ml", true},
+		{"find rust async runtimes", "find rust async runtimes", true},
+		{"Search transformers", "Search transformers", true},
+		{"search for the best pizza in town", "search for the best pizza in town", true},
+		{"find outlier detection papers", "find outlier detection papers", true},
+		{"!find out", "find out", true},
+		{"!find rust async runtimes", "find rust async runtimes", true},
+		{"!search transformers", "search transformers", true},
+		{"!ping", "ping", true},
+
+		// Chat that happens to start with a command word
+		{"ping me when you're done", "", false},
+		{"help me understand transformers", "", false},
+		{"find out why the build fails", "", false},
+		{"Find  out what changed", "", false},
+		{"find it odd that this works", "", false},
+		{"search me", "", false},
+		{"find", "", false},
+		{"add some context to your last answer", "", false},
+		{"merge these two ideas for me", "", false},
+		{"reindex everything please", "", false},
+		{"https://example.com/post ml", "", false},
+		{"what is a monad?", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			command, ok := messageCommand(tt.text)
+			if ok != tt.ok || command != tt.command {
+				t.Errorf("messageCommand(%q) = %q, %v, want %q, %v", tt.text, command, ok, tt.command, tt.ok)
+			}
+		})
+	}
+}
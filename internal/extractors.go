@@ -0,0 +1,350 @@
+package util
+
+import (
+	"net/url"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// Document is the structured content pulled out of a page. Date is the
+// publication date; Updated, Category and DOI are only known for papers.
+// Source names where the content came from, e.g. "direct" or "jina".
+type Document struct {
+	Title    string
+	Authors  []string
+	Date     string
+	Updated  string
+	Category string
+	DOI      string
+	Abstract string
+	Body     string
+	Source   string
+}
+
+// minAbstractChars is the length from which an abstract alone is enough to
+// summarize a document. Shorter ones, like a blog subtitle or a repository
+// description, are sent along with the body.
+const minAbstractChars = 200
+
+// Content is the text to summarize: the abstract when the page has a real one
+// and the body otherwise.
+func (d Document) Content() string {
+	if len([]rune(d.Abstract)) >= minAbstractChars || d.Body == "" {
+		return d.Abstract
+	}
+	if d.Abstract == "" {
+		return d.Body
+	}
+	return d.Abstract + "\n\n" + d.Body
+}
+
+// Extractor pulls a Document out of the pages of the sites it knows.
+type Extractor interface {
+	// Hosts are the hostnames the extractor handles, subdomains included.
+	Hosts() []string
+	Extract(doc *goquery.Document, pageURL *url.URL) (Document, error)
+}
+
+// Fetcher is implemented by extractors that can get a Document straight from
+// a site's API. It is tried before downloading the page.
+type Fetcher interface {
+	Fetch(pageURL *url.URL) (Document, error)
+}
+
+var (
+	extractorsMu sync.RWMutex
+	extractors   = map[string]Extractor{}
+)
+
+// RegisterExtractor makes e the extractor for its hosts, replacing any
+// extractor previously registered for them.
+func RegisterExtractor(e Extractor) {
+	extractorsMu.Lock()
+	defer extractorsMu.Unlock()
+	for _, host := range e.Hosts() {
+		extractors[strings.ToLower(host)] = e
+	}
+}
+
+func init() {
+	RegisterExtractor(arxivExtractor{})
+	RegisterExtractor(githubExtractor{})
+	RegisterExtractor(wikipediaExtractor{})
+	RegisterExtractor(blogExtractor{})
+}
+
+// extractorFor returns the extractor registered for host or one of its parent
+// domains, falling back to the generic extractor.
+func extractorFor(host string) Extractor {
+	extractorsMu.RLock()
+	defer extractorsMu.RUnlock()
+
+	host = strings.TrimPrefix(strings.ToLower(host), "www.")
+	for host != "" {
+		if e, ok := extractors[host]; ok {
+			return e
+		}
+		_, parent, found := strings.Cut(host, ".")
+		if !found {
+			break
+		}
+		host = parent
+	}
+	return genericExtractor{}
+}
+
+// extractDocument runs the extractor registered for the page's host, filling
+// anything it missed from the generic extractor.
+func extractDocument(doc *goquery.Document, pageURL *url.URL) (Document, error) {
+	host := ""
+	if pageURL != nil {
+		host = pageURL.Hostname()
+	}
+	extracted, err := extractorFor(host).Extract(doc, pageURL)
+	if err != nil {
+		return Document{}, err
+	}
+
+	if extracted.Title == "" || extracted.Content() == "" {
+		generic, _ := genericExtractor{}.Extract(doc, pageURL)
+		if extracted.Title == "" {
+			extracted.Title = generic.Title
+		}
+		if extracted.Content() == "" {
+			extracted.Body = generic.Body
+		}
+	}
+	return extracted, nil
+}
+
+// =============================== Site extractors =============================
+
+// genericExtractor handles any page using the usual metadata tags and the
+// page's main content, or its first paragraphs when none stands out.
+type genericExtractor struct{}
+
+func (genericExtractor) Hosts() []string { return nil }
+
+func (genericExtractor) Extract(doc *goquery.Document, pageURL *url.URL) (Document, error) {
+	body := mainContent(doc)
+	if body == "" {
+		body = paragraphs(doc.Find("p"), 10)
+	}
+	return Document{
+		Title:    pageTitle(doc),
+		Authors:  metaAll(doc, `meta[name="author"]`, `meta[property="article:author"]`),
+		Date:     publishedDate(doc),
+		Abstract: meta(doc, `meta[name="description"]`, `meta[property="og:description"]`),
+		Body:     body,
+	}, nil
+}
+
+// arxivExtractor reads arXiv abstract pages, which carry Highwire citation tags.
+type arxivExtractor struct{}
+
+func (arxivExtractor) Hosts() []string { return []string{"arxiv.org"} }
+
+func (arxivExtractor) Extract(doc *goquery.Document, pageURL *url.URL) (Document, error) {
+	title := meta(doc, `meta[name="citation_title"]`)
+	if title == "" {
+		title = strings.TrimPrefix(cleanText(doc.Find("h1.title").First().Text()), "Title:")
+	}
+	if title == "" {
+		// <title> looks like "[2305.14314] QLoRA: Efficient Finetuning..."
+		title = strings.TrimLeftFunc(cleanText(doc.Find("title").First().Text()), func(r rune) bool {
+			return !unicode.IsLetter(r)
+		})
+	}
+
+	abstract := meta(doc, `meta[name="citation_abstract"]`)
+	if abstract == "" {
+		abstract = strings.TrimPrefix(cleanText(doc.Find("blockquote.abstract").First().Text()), "Abstract:")
+	}
+
+	return Document{
+		Title:    strings.TrimSpace(title),
+		Authors:  metaAll(doc, `meta[name="citation_author"]`),
+		Date:     meta(doc, `meta[name="citation_date"]`, `meta[name="citation_online_date"]`),
+		Abstract: strings.TrimSpace(abstract),
+	}, nil
+}
+
+// githubExtractor reads repository pages: the description and the README.
+type githubExtractor struct{}
+
+func (githubExtractor) Hosts() []string { return []string{"github.com"} }
+
+func (githubExtractor) Extract(doc *goquery.Document, pageURL *url.URL) (Document, error) {
+	var authors []string
+	title := meta(doc, `meta[property="og:title"]`)
+	if pageURL != nil {
+		parts := strings.Split(strings.Trim(pageURL.Path, "/"), "/")
+		if len(parts) >= 1 && parts[0] != "" {
+			authors = []string{parts[0]}
+		}
+		if len(parts) >= 2 && title == "" {
+			title = parts[0] + "/" + parts[1]
+		}
+	}
+
+	description := meta(doc, `meta[property="og:description"]`, `meta[name="description"]`)
+	// GitHub appends " - owner/repo" or "Contribute to owner/repo development..."
+	if strings.HasPrefix(description, "Contribute to ") {
+		description = ""
+	}
+
+	readme := doc.Find("article.markdown-body").First()
+	return Document{
+		Title:    title,
+		Authors:  authors,
+		Date:     doc.Find("relative-time").First().AttrOr("datetime", ""),
+		Abstract: description,
+		Body:     structuredText(readme, 40),
+	}, nil
+}
+
+// wikipediaExtractor reads article text without references and infoboxes.
+type wikipediaExtractor struct{}
+
+func (wikipediaExtractor) Hosts() []string { return []string{"wikipedia.org"} }
+
+func (wikipediaExtractor) Extract(doc *goquery.Document, pageURL *url.URL) (Document, error) {
+	content := doc.Find("#mw-content-text .mw-parser-output").First()
+	content.Find("sup.reference, .mw-editsection, table, .navbox, .hatnote, style").Remove()
+
+	lead := ""
+	content.ChildrenFiltered("p").EachWithBreak(func(i int, s *goquery.Selection) bool {
+		lead = cleanText(s.Text())
+		return lead == ""
+	})
+
+	return Document{
+		Title:    cleanText(doc.Find("#firstHeading").First().Text()),
+		Date:     meta(doc, `meta[property="article:modified_time"]`),
+		Abstract: lead,
+		Body:     structuredText(content, 40),
+	}, nil
+}
+
+// blogExtractor reads Medium and Substack posts.
+type blogExtractor struct{}
+
+func (blogExtractor) Hosts() []string { return []string{"medium.com", "substack.com"} }
+
+func (blogExtractor) Extract(doc *goquery.Document, pageURL *url.URL) (Document, error) {
+	title := cleanText(doc.Find("article h1, h1.post-title").First().Text())
+	if title == "" {
+		title = pageTitle(doc)
+	}
+
+	subtitle := cleanText(doc.Find("h3.subtitle, h2.subtitle").First().Text())
+	if subtitle == "" {
+		subtitle = meta(doc, `meta[name="description"]`, `meta[property="og:description"]`)
+	}
+
+	body := doc.Find("div.available-content, div.body.markup").First()
+	if body.Length() == 0 {
+		body = doc.Find("article").First()
+	}
+
+	return Document{
+		Title:    title,
+		Authors:  metaAll(doc, `meta[name="author"]`, `meta[property="article:author"]`),
+		Date:     publishedDate(doc),
+		Abstract: subtitle,
+		Body:     structuredText(body, 40),
+	}, nil
+}
+
+// =============================== Helpers functions ==========================
+
+// cleanText collapses whitespace.
+func cleanText(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// meta returns the content of the first matching meta tag that has one.
+func meta(doc *goquery.Document, selectors ...string) string {
+	for _, selector := range selectors {
+		if content := cleanText(doc.Find(selector).First().AttrOr("content", "")); content != "" {
+			return content
+		}
+	}
+	return ""
+}
+
+// metaAll returns the contents of every meta tag matching the first selector
+// that matches at all.
+func metaAll(doc *goquery.Document, selectors ...string) []string {
+	for _, selector := range selectors {
+		values := []string{}
+		doc.Find(selector).Each(func(i int, s *goquery.Selection) {
+			if content := cleanText(s.AttrOr("content", "")); content != "" {
+				values = append(values, content)
+			}
+		})
+		if len(values) > 0 {
+			return values
+		}
+	}
+	return nil
+}
+
+func pageTitle(doc *goquery.Document) string {
+	if title := meta(doc, `meta[property="og:title"]`); title != "" {
+		return title
+	}
+	for _, selector := range []string{"title", "h1", "h2"} {
+		if title := cleanText(doc.Find(selector).First().Text()); title != "" {
+			return title
+		}
+	}
+	return ""
+}
+
+func publishedDate(doc *goquery.Document) string {
+	if date := meta(doc, `meta[property="article:published_time"]`, `meta[name="date"]`); date != "" {
+		return date
+	}
+	return doc.Find("time[datetime]").First().AttrOr("datetime", "")
+}
+
+// paragraphs joins the text of the first limit non-empty elements in sel.
+func paragraphs(sel *goquery.Selection, limit int) string {
+	texts := []string{}
+	sel.EachWithBreak(func(i int, s *goquery.Selection) bool {
+		if text := cleanText(s.Text()); text != "" {
+			texts = append(texts, text)
+		}
+		return len(texts) < limit
+	})
+	return strings.Join(texts, "\n\n")
+}
+
+// structuredText renders the headings, paragraphs and list items under sel,
+// keeping headings as markdown "#" lines and list items as "-" bullets.
+func structuredText(sel *goquery.Selection, limit int) string {
+	texts := []string{}
+	sel.Find("h1, h2, h3, h4, p, li, pre").EachWithBreak(func(i int, s *goquery.Selection) bool {
+		// Paragraphs inside list items are covered by the item itself
+		if goquery.NodeName(s) == "p" && s.ParentsFiltered("li").Length() > 0 {
+			return true
+		}
+		text := cleanText(s.Text())
+		if text == "" {
+			return true
+		}
+		switch name := goquery.NodeName(s); name {
+		case "h1", "h2", "h3", "h4":
+			text = strings.Repeat("#", int(name[1]-'0')) + " " + text
+		case "li":
+			text = "- " + text
+		}
+		texts = append(texts, text)
+		return len(texts) < limit
+	})
+	return strings.Join(texts, "\n")
+}
@@ -0,0 +1,374 @@
+package util
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Defaults for the shared HTTP fetcher, overridable from .env in InitFetcher.
+const (
+	defaultUserAgent    = "BotBot/1.0 (+https://github.com/HaojiongZhang/BotBot)"
+	defaultFetchTimeout = 20 * time.Second
+	defaultFetchRetries = 2
+	defaultMaxBodyBytes = pdfMaxBytes
+
+	maxRedirects   = 5
+	robotsTTL      = 24 * time.Hour
+	retryBaseDelay = 500 * time.Millisecond
+	maxRetryDelay  = 10 * time.Second
+)
+
+var fetchClient = newHTTPFetcher(defaultUserAgent, defaultFetchTimeout, defaultMaxBodyBytes, defaultFetchRetries, false)
+
+// errRobotsDisallowed is returned when robots.txt asks BotBot not to fetch a page.
+var errRobotsDisallowed = errors.New("disallowed by robots.txt")
+
+// InitFetcher configures the HTTP client every scraper goes through:
+// SCRAPE_USER_AGENT, SCRAPE_TIMEOUT (per request, e.g. "20s"),
+// SCRAPE_MAX_BYTES, SCRAPE_RETRIES and SCRAPE_RESPECT_ROBOTS.
+func InitFetcher() {
+	userAgent := defaultUserAgent
+	if v := os.Getenv("SCRAPE_USER_AGENT"); v != "" {
+		userAgent = v
+	}
+
+	timeout := defaultFetchTimeout
+	if v := os.Getenv("SCRAPE_TIMEOUT"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			log.Fatalf("Invalid SCRAPE_TIMEOUT %q", v)
+		}
+		timeout = d
+	}
+
+	maxBytes := int64(defaultMaxBodyBytes)
+	if v := os.Getenv("SCRAPE_MAX_BYTES"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n <= 0 {
+			log.Fatalf("Invalid SCRAPE_MAX_BYTES %q", v)
+		}
+		maxBytes = n
+	}
+
+	retries := defaultFetchRetries
+	if v := os.Getenv("SCRAPE_RETRIES"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			log.Fatalf("Invalid SCRAPE_RETRIES %q", v)
+		}
+		retries = n
+	}
+
+	respectRobots := false
+	if v := os.Getenv("SCRAPE_RESPECT_ROBOTS"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			log.Fatalf("Invalid SCRAPE_RESPECT_ROBOTS %q", v)
+		}
+		respectRobots = b
+	}
+
+	fetchClient = newHTTPFetcher(userAgent, timeout, maxBytes, retries, respectRobots)
+	PrintDebug(fmt.Sprintf("Fetcher: timeout %s, max %d bytes, %d retries, robots.txt %t",
+		timeout, maxBytes, retries, respectRobots))
+}
+
+// fetchResponse is a fully read, decompressed HTTP response.
+type fetchResponse struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+}
+
+// statusError is returned for responses other than 200 OK.
+type statusError struct {
+	URL        string
+	StatusCode int
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("%s: status code %d", e.URL, e.StatusCode)
+}
+
+// httpFetcher is the HTTP client for scraping. Each request has its own deadline,
+// bodies are capped at maxBytes, and rate limits and server errors are
+// retried with exponential backoff.
+type httpFetcher struct {
+	client        *http.Client
+	userAgent     string
+	timeout       time.Duration
+	maxBytes      int64
+	retries       int
+	respectRobots bool
+
+	robotsMutex sync.Mutex
+	robots      map[string]*robotsRules
+}
+
+func newHTTPFetcher(userAgent string, timeout time.Duration, maxBytes int64, retries int, respectRobots bool) *httpFetcher {
+	return &httpFetcher{
+		client: &http.Client{
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				if len(via) > maxRedirects {
+					return fmt.Errorf("stopped after %d redirects", maxRedirects)
+				}
+				if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+					return fmt.Errorf("refusing redirect to %s", req.URL.Scheme)
+				}
+				return nil
+			},
+		},
+		userAgent:     userAgent,
+		timeout:       timeout,
+		maxBytes:      maxBytes,
+		retries:       retries,
+		respectRobots: respectRobots,
+		robots:        make(map[string]*robotsRules),
+	}
+}
+
+// Get fetches rawURL and returns the response if it is 200 OK.
+func (f *httpFetcher) Get(rawURL string) (*fetchResponse, error) {
+	return f.Do(rawURL, nil)
+}
+
+// Do fetches rawURL with extra request headers, retrying 429 and 5xx
+// responses and network errors.
+func (f *httpFetcher) Do(rawURL string, header http.Header) (*fetchResponse, error) {
+	var lastErr error
+	for attempt := 0; attempt <= f.retries; attempt++ {
+		resp, wait, retry, err := f.do(rawURL, header)
+		if err == nil {
+			return resp, nil
+		}
+		lastErr = err
+		if !retry || attempt == f.retries {
+			break
+		}
+
+		if wait == 0 {
+			wait = backoff(attempt)
+		}
+		PrintDebug(fmt.Sprintf("Retrying %s in %s: %v", rawURL, wait, err))
+		time.Sleep(wait)
+	}
+	return nil, lastErr
+}
+
+// do makes a single request. On failure it reports whether the request is
+// worth retrying and how long the server asked to wait first, if it did.
+func (f *httpFetcher) do(rawURL string, header http.Header) (*fetchResponse, time.Duration, bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), f.timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, 0, false, fmt.Errorf("invalid request: %w", err)
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	req.Header.Set("User-Agent", f.userAgent)
+	req.Header.Set("Accept-Encoding", "gzip")
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, 0, true, fmt.Errorf("error fetching %s: %w", rawURL, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		// Drain a little so the connection can be reused
+		io.Copy(io.Discard, io.LimitReader(resp.Body, 4<<10))
+		err := &statusError{URL: rawURL, StatusCode: resp.StatusCode}
+		return nil, retryAfter(resp.Header.Get("Retry-After")), retryableStatus(resp.StatusCode), err
+	}
+
+	body := io.Reader(resp.Body)
+	if strings.EqualFold(resp.Header.Get("Content-Encoding"), "gzip") {
+		gz, err := gzip.NewReader(resp.Body)
+		if err != nil {
+			return nil, 0, false, fmt.Errorf("error decompressing %s: %w", rawURL, err)
+		}
+		defer gz.Close()
+		body = gz
+		resp.Header.Del("Content-Encoding")
+	}
+
+	data, err := io.ReadAll(io.LimitReader(body, f.maxBytes+1))
+	if err != nil {
+		return nil, 0, true, fmt.Errorf("error reading %s: %w", rawURL, err)
+	}
+	if int64(len(data)) > f.maxBytes {
+		return nil, 0, false, fmt.Errorf("%s is larger than %d bytes", rawURL, f.maxBytes)
+	}
+
+	return &fetchResponse{StatusCode: resp.StatusCode, Header: resp.Header, Body: data}, 0, false, nil
+}
+
+// retryableStatus reports whether a response is worth retrying.
+func retryableStatus(code int) bool {
+	return code == http.StatusTooManyRequests || (code >= 500 && code != http.StatusNotImplemented)
+}
+
+// backoff returns the delay before retry attempt n, doubling each time with
+// some jitter so retries from several messages don't line up.
+func backoff(attempt int) time.Duration {
+	delay := retryBaseDelay << attempt
+	if delay > maxRetryDelay {
+		delay = maxRetryDelay
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// retryAfter parses a Retry-After header given in seconds or as a date,
+// capped at maxRetryDelay.
+func retryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	var delay time.Duration
+	if seconds, err := strconv.Atoi(strings.TrimSpace(value)); err == nil {
+		delay = time.Duration(seconds) * time.Second
+	} else if date, err := http.ParseTime(value); err == nil {
+		delay = time.Until(date)
+	}
+	if delay < 0 {
+		return 0
+	}
+	if delay > maxRetryDelay {
+		return maxRetryDelay
+	}
+	return delay
+}
+
+// =============================== robots.txt ==================================
+
+// robotsRules are the Allow and Disallow path prefixes that apply to BotBot.
+type robotsRules struct {
+	allow    []string
+	disallow []string
+	fetched  time.Time
+}
+
+// Allowed reports whether robots.txt lets BotBot fetch pageURL. It always
+// returns true unless SCRAPE_RESPECT_ROBOTS is set. A missing or unreadable
+// robots.txt allows everything.
+func (f *httpFetcher) Allowed(pageURL *url.URL) bool {
+	if !f.respectRobots {
+		return true
+	}
+
+	origin := pageURL.Scheme + "://" + pageURL.Host
+	f.robotsMutex.Lock()
+	rules, ok := f.robots[origin]
+	f.robotsMutex.Unlock()
+
+	if !ok || time.Since(rules.fetched) > robotsTTL {
+		rules = &robotsRules{}
+		resp, err := f.Get(origin + "/robots.txt")
+		if err != nil {
+			PrintDebug(fmt.Sprintf("No robots.txt for %s: %v", origin, err))
+		} else {
+			rules = parseRobots(resp.Body, f.userAgent)
+		}
+		rules.fetched = time.Now()
+		f.robotsMutex.Lock()
+		f.robots[origin] = rules
+		f.robotsMutex.Unlock()
+	}
+
+	path := pageURL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	if pageURL.RawQuery != "" {
+		path += "?" + pageURL.RawQuery
+	}
+	return rules.allowed(path)
+}
+
+// allowed applies the longest matching rule, with Allow winning ties.
+func (r *robotsRules) allowed(path string) bool {
+	longestAllow, longestDisallow := -1, -1
+	for _, prefix := range r.allow {
+		if strings.HasPrefix(path, prefix) && len(prefix) > longestAllow {
+			longestAllow = len(prefix)
+		}
+	}
+	for _, prefix := range r.disallow {
+		if strings.HasPrefix(path, prefix) && len(prefix) > longestDisallow {
+			longestDisallow = len(prefix)
+		}
+	}
+	return longestDisallow < 0 || longestAllow >= longestDisallow
+}
+
+// parseRobots reads the group of a robots.txt that matches userAgent, or the
+// "*" group if none does. Wildcards inside paths are not supported.
+func parseRobots(data []byte, userAgent string) *robotsRules {
+	product := strings.ToLower(strings.SplitN(userAgent, "/", 2)[0])
+
+	groups := map[string]*robotsRules{}
+	var current []string
+	inRules := false
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.TrimSpace(value)
+
+		switch key {
+		case "user-agent":
+			// Consecutive User-agent lines share the rules that follow
+			if inRules {
+				current = nil
+				inRules = false
+			}
+			agent := strings.ToLower(value)
+			current = append(current, agent)
+			if groups[agent] == nil {
+				groups[agent] = &robotsRules{}
+			}
+		case "allow", "disallow":
+			inRules = true
+			if value == "" {
+				continue
+			}
+			for _, agent := range current {
+				if key == "allow" {
+					groups[agent].allow = append(groups[agent].allow, value)
+				} else {
+					groups[agent].disallow = append(groups[agent].disallow, value)
+				}
+			}
+		}
+	}
+
+	if rules, ok := groups[product]; ok {
+		return rules
+	}
+	if rules, ok := groups["*"]; ok {
+		return rules
+	}
+	return &robotsRules{}
+}
@@ -0,0 +1,282 @@
+package util
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// statusSequence answers with the statuses in turn, repeating the last one,
+// and counts the requests.
+func statusSequence(calls *atomic.Int32, statuses ...int) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		n := int(calls.Add(1)) - 1
+		status := statuses[min(n, len(statuses)-1)]
+		if status == http.StatusOK {
+			fmt.Fprint(w, "hello")
+			return
+		}
+		http.Error(w, http.StatusText(status), status)
+	}
+}
+
+func TestFetcherRetries(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []int
+		calls    int32
+		wantErr  bool
+	}{
+		{"server error then ok", []int{http.StatusInternalServerError, http.StatusOK}, 2, false},
+		{"rate limited then ok", []int{http.StatusTooManyRequests, http.StatusOK}, 2, false},
+		{"gives up", []int{http.StatusBadGateway}, 2, true},
+		{"not found not retried", []int{http.StatusNotFound, http.StatusOK}, 1, true},
+		{"not implemented not retried", []int{http.StatusNotImplemented, http.StatusOK}, 1, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls atomic.Int32
+			server := httptest.NewServer(statusSequence(&calls, tt.statuses...))
+			defer server.Close()
+
+			fetcher := newHTTPFetcher("BotBot-test", 5*time.Second, 1<<20, 1, false)
+			resp, err := fetcher.Get(server.URL)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if err == nil && string(resp.Body) != "hello" {
+				t.Errorf("Body = %q", resp.Body)
+			}
+			if got := calls.Load(); got != tt.calls {
+				t.Errorf("got %d requests, want %d", got, tt.calls)
+			}
+		})
+	}
+}
+
+func TestFetcherRetryAfter(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "slow down", http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprint(w, "hello")
+	}))
+	defer server.Close()
+
+	fetcher := newHTTPFetcher("BotBot-test", 5*time.Second, 1<<20, 1, false)
+	start := time.Now()
+	if _, err := fetcher.Get(server.URL); err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("retried after %s, before Retry-After", elapsed)
+	}
+
+	if got := retryAfter("120"); got != maxRetryDelay {
+		t.Errorf("retryAfter(120) = %s, want the %s cap", got, maxRetryDelay)
+	}
+	if got := retryAfter(time.Now().Add(-time.Minute).UTC().Format(http.TimeFormat)); got != 0 {
+		t.Errorf("retryAfter(past date) = %s, want 0", got)
+	}
+}
+
+func gzipBytes(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	if _, err := gz.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestFetcherBody(t *testing.T) {
+	const maxBytes = 1000
+	small := strings.Repeat("a", maxBytes)
+	large := strings.Repeat("a", maxBytes+1)
+
+	tests := []struct {
+		name    string
+		body    []byte
+		gzipped bool
+		want    string
+		wantErr bool
+	}{
+		{"at the limit", []byte(small), false, small, false},
+		{"over the limit", []byte(large), false, "", true},
+		{"gzip", gzipBytes(t, []byte("<html>hello</html>")), true, "<html>hello</html>", false},
+		// The limit applies to the decompressed body
+		{"gzip over the limit", gzipBytes(t, []byte(large)), true, "", true},
+		{"broken gzip", []byte("not gzip"), true, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var acceptEncoding, userAgent string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				acceptEncoding = r.Header.Get("Accept-Encoding")
+				userAgent = r.Header.Get("User-Agent")
+				if tt.gzipped {
+					w.Header().Set("Content-Encoding", "gzip")
+				}
+				w.Header().Set("Content-Length", strconv.Itoa(len(tt.body)))
+				w.Write(tt.body)
+			}))
+			defer server.Close()
+
+			fetcher := newHTTPFetcher("BotBot-test", 5*time.Second, maxBytes, 0, false)
+			resp, err := fetcher.Get(server.URL)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if acceptEncoding != "gzip" || userAgent != "BotBot-test" {
+				t.Errorf("sent Accept-Encoding %q, User-Agent %q", acceptEncoding, userAgent)
+			}
+			if err != nil {
+				return
+			}
+			if string(resp.Body) != tt.want {
+				t.Errorf("Body = %q, want %q", resp.Body, tt.want)
+			}
+			if resp.Header.Get("Content-Encoding") != "" {
+				t.Error("Content-Encoding kept on the decompressed body")
+			}
+		})
+	}
+}
+
+func TestFetcherRedirects(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/hop/", func(w http.ResponseWriter, r *http.Request) {
+		n, _ := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/hop/"))
+		if n == 0 {
+			fmt.Fprint(w, "arrived")
+			return
+		}
+		http.Redirect(w, r, fmt.Sprintf("/hop/%d", n-1), http.StatusFound)
+	})
+	mux.HandleFunc("/ftp", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "ftp://example.com/file", http.StatusFound)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	fetcher := newHTTPFetcher("BotBot-test", 5*time.Second, 1<<20, 0, false)
+	resp, err := fetcher.Get(fmt.Sprintf("%s/hop/%d", server.URL, maxRedirects))
+	if err != nil {
+		t.Fatalf("following %d redirects: %v", maxRedirects, err)
+	}
+	if string(resp.Body) != "arrived" {
+		t.Errorf("Body = %q", resp.Body)
+	}
+	if _, err := fetcher.Get(fmt.Sprintf("%s/hop/%d", server.URL, maxRedirects+1)); err == nil {
+		t.Errorf("followed %d redirects", maxRedirects+1)
+	}
+	if _, err := fetcher.Get(server.URL + "/ftp"); err == nil {
+		t.Error("followed a redirect to ftp")
+	}
+}
+
+func TestParseRobots(t *testing.T) {
+	const robots = `# Example robots.txt
+User-agent: *
+Disallow: /private/
+Allow: /private/public/
+Disallow: /search
+
+User-agent: BotBot
+User-agent: OtherBot
+Disallow: /drafts/
+Allow: /drafts/published
+Disallow: /drafts/published/secret
+
+User-agent: GPTBot
+Disallow: /
+`
+
+	tests := []struct {
+		name      string
+		robots    string
+		userAgent string
+		path      string
+		want      bool
+	}{
+		{"own group", robots, "BotBot/1.0 (+https://example.com)", "/drafts/post", false},
+		{"own group ignores star group", robots, "BotBot/1.0", "/private/page", true},
+		{"agent match ignores case", robots, "botbot", "/drafts/post", false},
+		{"shared group", robots, "OtherBot/2.0", "/drafts/post", false},
+		{"longer allow wins", robots, "BotBot/1.0", "/drafts/published/post", true},
+		{"longer disallow wins", robots, "BotBot/1.0", "/drafts/published/secret/1", false},
+		{"star group", robots, "Unknown/1.0", "/private/page", false},
+		{"star group allow", robots, "Unknown/1.0", "/private/public/page", true},
+		{"query string", robots, "Unknown/1.0", "/search?q=rust", false},
+		{"no rule", robots, "Unknown/1.0", "/blog/post", true},
+		{"tie goes to allow", "User-agent: *\nDisallow: /page\nAllow: /page\n", "BotBot", "/page", true},
+		{"empty disallow allows all", "User-agent: *\nDisallow:\n", "BotBot", "/anything", true},
+		{"comments and blank lines", "User-agent: * # everyone\n\nDisallow: /tmp # scratch\n", "BotBot", "/tmp/file", false},
+		{"no matching group", "User-agent: GPTBot\nDisallow: /\n", "BotBot", "/page", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rules := parseRobots([]byte(tt.robots), tt.userAgent)
+			if got := rules.allowed(tt.path); got != tt.want {
+				t.Errorf("allowed(%q) for %q = %v, want %v", tt.path, tt.userAgent, got, tt.want)
+			}
+		})
+	}
+}
+
+func mustParseURL(t *testing.T, rawURL string) *url.URL {
+	t.Helper()
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u
+}
+
+func TestFetcherAllowed(t *testing.T) {
+	var robotsCalls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/robots.txt" {
+			robotsCalls.Add(1)
+			fmt.Fprint(w, "User-agent: *\nDisallow: /private/\n")
+			return
+		}
+		fmt.Fprint(w, "page")
+	}))
+	defer server.Close()
+
+	fetcher := newHTTPFetcher("BotBot/1.0", 5*time.Second, 1<<20, 0, true)
+	for path, want := range map[string]bool{"/private/page": false, "/public/page": true, "": true} {
+		u := mustParseURL(t, server.URL+path)
+		if got := fetcher.Allowed(u); got != want {
+			t.Errorf("Allowed(%s) = %v, want %v", u, got, want)
+		}
+	}
+	if got := robotsCalls.Load(); got != 1 {
+		t.Errorf("fetched robots.txt %d times, want once", got)
+	}
+
+	// Without SCRAPE_RESPECT_ROBOTS nothing is checked
+	fetcher = newHTTPFetcher("BotBot/1.0", 5*time.Second, 1<<20, 0, false)
+	if !fetcher.Allowed(mustParseURL(t, server.URL+"/private/page")) {
+		t.Error("Allowed checked robots.txt while disabled")
+	}
+}
@@ -0,0 +1,125 @@
+package util
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+
+	"github.com/slack-go/slack"
+)
+
+// maxFileBytes is the largest upload BotBot will download.
+const maxFileBytes = pdfMaxBytes
+
+// eventFiles reads the files attached to a message from a raw Events API
+// payload. slackevents.AppMentionEvent doesn't expose them.
+func eventFiles(payload json.RawMessage) []slack.File {
+	var envelope struct {
+		Event struct {
+			Files []slack.File `json:"files"`
+		} `json:"event"`
+	}
+	if err := json.Unmarshal(payload, &envelope); err != nil {
+		return nil
+	}
+	return envelope.Event.Files
+}
+
+// fileKind returns "pdf", "markdown" or "html" for the uploads BotBot can
+// read, and "" for anything else.
+func fileKind(file slack.File) string {
+	switch {
+	case file.Filetype == "pdf" || file.Mimetype == "application/pdf":
+		return "pdf"
+	case file.Filetype == "markdown" || file.Mimetype == "text/markdown":
+		return "markdown"
+	case file.Filetype == "html" || file.Mimetype == "text/html":
+		return "html"
+	}
+	switch strings.ToLower(filepath.Ext(file.Name)) {
+	case ".pdf":
+		return "pdf"
+	case ".md", ".markdown":
+		return "markdown"
+	case ".html", ".htm":
+		return "html"
+	}
+	return ""
+}
+
+// SaveFiles summarizes the readable files attached to a message and adds each
+// to Notion, titled with the file name and linking to its Slack permalink.
+func SaveFiles(client *slack.Client, files []slack.File, userLabels []string, origin Origin) string {
+	responses := []string{}
+	for _, file := range files {
+		responses = append(responses, saveFile(client, file, userLabels, origin))
+	}
+	return strings.Join(responses, "\n\n")
+}
+
+func saveFile(client *slack.Client, file slack.File, userLabels []string, origin Origin) string {
+	kind := fileKind(file)
+	if kind == "" {
+		return fmt.Sprintf("I can only read PDF, Markdown and HTML files, so I skipped *%s*.", file.Name)
+	}
+	if file.Size > maxFileBytes {
+		return fmt.Sprintf("*%s* is too large for me to read.", file.Name)
+	}
+
+	existing, err := FindEntryByURL(file.Permalink)
+	if err != nil {
+		log.Printf("Failed to check for duplicate file: %v", err)
+	} else if existing != nil {
+		entry := entryFromPage(*existing)
+		return fmt.Sprintf("*%s* is already in Notion: <%s|%s>", file.Name, entry.PageURL, entry.Title)
+	}
+
+	text, err := fileText(client, file, kind)
+	if err != nil {
+		log.Printf("Failed to read file %s: %v", file.Name, err)
+		return fmt.Sprintf("Sorry, I couldn't read *%s*.", file.Name)
+	}
+
+	summary, err := summarizeContent(llmClient, text)
+	if err != nil {
+		log.Printf("Failed to summarize file %s: %v", file.Name, err)
+		return fmt.Sprintf("Sorry, I couldn't summarize *%s*.", file.Name)
+	}
+
+	labels, err := saveEntry(Document{Title: file.Name}, file.Permalink, userLabels, summary, origin)
+	if err != nil {
+		return fmt.Sprintf("I couldn't save *%s*. %s", file.Name, describeNotionError(err))
+	}
+	return fmt.Sprintf("I have added *%s* to Notion!\nLabels: %s\nHere's a short summary: %s",
+		file.Name, strings.Join(labels, ", "), summary.Text)
+}
+
+// fileText downloads a file with the bot token and extracts its text.
+func fileText(client *slack.Client, file slack.File, kind string) (string, error) {
+	downloadURL := file.URLPrivateDownload
+	if downloadURL == "" {
+		downloadURL = file.URLPrivate
+	}
+
+	var buf bytes.Buffer
+	if err := client.GetFile(downloadURL, &buf); err != nil {
+		return "", fmt.Errorf("error downloading file: %w", err)
+	}
+
+	switch kind {
+	case "pdf":
+		_, text, err := readPDF(&buf)
+		return text, err
+	case "html":
+		doc, err := parseHTML(&buf, nil)
+		if err != nil {
+			return "", err
+		}
+		return truncateText(doc.Content(), maxContentChars), nil
+	default:
+		return truncateText(buf.String(), maxContentChars), nil
+	}
+}
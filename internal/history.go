@@ -0,0 +1,150 @@
+package util
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pkoukk/tiktoken-go"
+	tiktoken_loader "github.com/pkoukk/tiktoken-go-loader"
+)
+
+const (
+	defaultHistoryBudget = 1500
+	historySummaryPrefix = "Summary of earlier conversation: "
+)
+
+var (
+	historyBudget = defaultHistoryBudget
+
+	tokenEncoder     *tiktoken.Tiktoken
+	tokenEncoderOnce sync.Once
+)
+
+// InitHistory reads the HISTORY_TOKEN_BUDGET environment variable, the number
+// of tokens of chat history sent to the model with each message.
+func InitHistory() {
+	if v := os.Getenv("HISTORY_TOKEN_BUDGET"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			log.Fatalf("Invalid HISTORY_TOKEN_BUDGET %q", v)
+		}
+		historyBudget = n
+	}
+}
+
+// CountTokens returns the number of cl100k tokens in text. Local models use
+// other tokenizers, but it is close enough for budgeting. The encoding is
+// built into the binary rather than downloaded on first use, so counting
+// never waits on the network. If it can't be loaded it falls back to roughly
+// four characters per token.
+func CountTokens(text string) int {
+	tokenEncoderOnce.Do(func() {
+		tiktoken.SetBpeLoader(tiktoken_loader.NewOfflineLoader())
+		enc, err := tiktoken.GetEncoding("cl100k_base")
+		if err != nil {
+			log.Printf("Failed to load tokenizer, approximating token counts: %v", err)
+			return
+		}
+		tokenEncoder = enc
+	})
+	if tokenEncoder == nil {
+		return len([]rune(text))/4 + 1
+	}
+	return len(tokenEncoder.Encode(text, nil, nil))
+}
+
+// fitHistory trims history to the token budget. The system message (the first
+// line) and the rolling summary right after it are always kept; the remaining
+// turns are kept newest first until the budget runs out.
+func fitHistory(history []string, budget int) []string {
+	if len(history) == 0 {
+		return history
+	}
+
+	pinned := 1
+	if len(history) > 1 && strings.HasPrefix(history[1], historySummaryPrefix) {
+		pinned = 2
+	}
+
+	used := 0
+	for _, line := range history[:pinned] {
+		used += CountTokens(line)
+	}
+
+	start := len(history)
+	for start > pinned {
+		tokens := CountTokens(history[start-1])
+		if used+tokens > budget {
+			break
+		}
+		used += tokens
+		start--
+	}
+
+	if start > pinned {
+		PrintDebug(fmt.Sprintf("Dropped %d history lines over the %d token budget", start-pinned, budget))
+	}
+	return append(append([]string{}, history[:pinned]...), history[start:]...)
+}
+
+// CompactHistory folds the oldest turns of a conversation into a rolling
+// LLM-generated summary once the stored history outgrows the token budget.
+// The most recent turns, up to half the budget, are kept verbatim.
+func CompactHistory(key string) error {
+	turns, err := memory.Load(key)
+	if err != nil {
+		return err
+	}
+
+	total := 0
+	for _, line := range turns {
+		total += CountTokens(line)
+	}
+	if total <= historyBudget {
+		return nil
+	}
+
+	keep := 0
+	kept := 0
+	for keep < len(turns) {
+		tokens := CountTokens(turns[len(turns)-1-keep])
+		if kept+tokens > historyBudget/2 {
+			break
+		}
+		kept += tokens
+		keep++
+	}
+	folded := turns[:len(turns)-keep]
+	if len(folded) == 0 {
+		return nil
+	}
+
+	summary, err := summarizeTurns(folded)
+	if err != nil {
+		return fmt.Errorf("failed to summarize history: %w", err)
+	}
+	PrintDebug("Rolling summary for " + key + ": " + summary)
+	return memory.Replace(key, len(folded), historySummaryPrefix+summary)
+}
+
+// summarizeTurns asks the LLM to condense the given lines, which may start with
+// a previous summary, into a short paragraph.
+func summarizeTurns(turns []string) (string, error) {
+	prompt := fmt.Sprintf(`Summarize the following conversation between a user and a Slack bot in under 5 sentences.
+Keep names, links, decisions and any facts the user shared about themselves. If it starts with an earlier summary, fold it in.
+Return only the summary, with no additional text.
+
+%s`, strings.Join(turns, "\n"))
+
+	ctx := context.Background()
+	completion, err := generate(ctx, prompt)
+	if err != nil {
+		return "", err
+	}
+	return strings.Join(strings.Fields(strings.TrimPrefix(strings.TrimSpace(completion), historySummaryPrefix)), " "), nil
+}
@@ -0,0 +1,49 @@
+package util
+
+import "testing"
+
+func TestCountTokens(t *testing.T) {
+	// Counted with the cl100k_base encoding, which must load without network
+	tests := []struct {
+		text string
+		want int
+	}{
+		{"", 0},
+		{"hello world", 2},
+		{"BotBot saves links to Notion.", 8},
+	}
+	for _, tt := range tests {
+		if got := CountTokens(tt.text); got != tt.want {
+			t.Errorf("CountTokens(%q) = %d, want %d", tt.text, got, tt.want)
+		}
+	}
+	if tokenEncoder == nil {
+		t.Error("tokenizer did not load, counts are approximated")
+	}
+}
+
+func TestFitHistory(t *testing.T) {
+	history := []string{
+		"System: be helpful",
+		historySummaryPrefix + "the user asked about Go",
+		"User: first question about something long ago",
+		"Bot: first answer",
+		"User: latest question",
+		"Bot: latest answer",
+	}
+	budget := 0
+	for _, line := range append(history[:2:2], history[4:]...) {
+		budget += CountTokens(line)
+	}
+
+	got := fitHistory(history, budget)
+	want := []string{history[0], history[1], history[4], history[5]}
+	if len(got) != len(want) {
+		t.Fatalf("fitHistory() = %q, want %q", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("fitHistory()[%d] = %q, want %q", i, got[i], want[i])
+		}
+	}
+}
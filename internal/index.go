@@ -0,0 +1,271 @@
+package util
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tmc/langchaingo/embeddings"
+)
+
+const defaultIndexFile = "logs/index.json"
+
+var (
+	embedder embeddings.Embedder
+	index    *vectorIndex
+)
+
+// indexedEntry is a saved link together with the embedding of its text.
+type indexedEntry struct {
+	Entry
+	PageID string    `json:"page_id"`
+	Vector []float32 `json:"vector"`
+}
+
+// vectorIndex is a small on-disk embedding index of the Notion library. The
+// whole index lives in memory and is searched by brute-force cosine similarity,
+// which is plenty for a team reading list.
+type vectorIndex struct {
+	path string
+
+	mu      sync.RWMutex
+	entries []indexedEntry
+	// updated collects the entries upserted while a rebuild runs, so they
+	// aren't lost when the rebuilt entries replace the old ones.
+	updated map[string]indexedEntry
+
+	saveMu    sync.Mutex // serializes writes to path
+	rebuildMu sync.Mutex // one rebuild at a time
+}
+
+// InitIndex loads the embedding index from INDEX_FILE (logs/index.json by
+// default). It must be called after InitLLM.
+func InitIndex() {
+	path := os.Getenv("INDEX_FILE")
+	if path == "" {
+		path = defaultIndexFile
+	}
+
+	index = &vectorIndex{path: path}
+	if err := index.load(); err != nil {
+		log.Printf("Error loading embedding index: %v", err)
+	}
+	PrintDebug(fmt.Sprintf("Loaded %d entries from the embedding index", index.Len()))
+}
+
+// entryText is what gets embedded for an entry.
+func entryText(entry Entry) string {
+	return fmt.Sprintf("%s\nLabels: %s\n%s", entry.Title, strings.Join(entry.Labels, ", "), entry.Summary)
+}
+
+// IndexEntry embeds a saved entry and adds it to the index.
+func IndexEntry(pageID string, entry Entry) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	vector, err := embedder.EmbedQuery(ctx, entryText(entry))
+	if err != nil {
+		return fmt.Errorf("failed to embed entry: %w", err)
+	}
+	index.Upsert(indexedEntry{Entry: entry, PageID: pageID, Vector: vector})
+	return index.save()
+}
+
+// FindEntries returns the limit entries semantically closest to query.
+func FindEntries(query string, limit int) ([]Entry, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	vector, err := embedder.EmbedQuery(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to embed query: %w", err)
+	}
+	return index.Search(vector, limit), nil
+}
+
+// RebuildIndex re-embeds every row of the Notion database and replaces the
+// index with the result. It returns the number of entries indexed.
+func RebuildIndex() (int, error) {
+	index.rebuildMu.Lock()
+	defer index.rebuildMu.Unlock()
+	index.startRebuild()
+	defer index.finishRebuild(nil)
+
+	pages, err := allDatabasePages()
+	if err != nil {
+		return 0, err
+	}
+
+	entries := make([]Entry, 0, len(pages))
+	for _, page := range pages {
+		entries = append(entries, entryFromPage(page))
+	}
+	texts := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		texts = append(texts, entryText(entry))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+	vectors, err := embedder.EmbedDocuments(ctx, texts)
+	if err != nil {
+		return 0, fmt.Errorf("failed to embed entries: %w", err)
+	}
+	if len(vectors) != len(entries) {
+		return 0, fmt.Errorf("got %d embeddings for %d entries", len(vectors), len(entries))
+	}
+
+	rebuilt := make([]indexedEntry, 0, len(entries))
+	for i, entry := range entries {
+		rebuilt = append(rebuilt, indexedEntry{Entry: entry, PageID: string(pages[i].ID), Vector: vectors[i]})
+	}
+
+	return index.finishRebuild(rebuilt), index.save()
+}
+
+func (idx *vectorIndex) Len() int {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	return len(idx.entries)
+}
+
+// Upsert adds an entry, replacing any previous version of the same page.
+func (idx *vectorIndex) Upsert(entry indexedEntry) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	if idx.updated != nil {
+		idx.updated[entry.PageID] = entry
+	}
+	idx.entries = upsertEntry(idx.entries, entry)
+}
+
+func upsertEntry(entries []indexedEntry, entry indexedEntry) []indexedEntry {
+	for i := range entries {
+		if entries[i].PageID == entry.PageID {
+			entries[i] = entry
+			return entries
+		}
+	}
+	return append(entries, entry)
+}
+
+// startRebuild starts collecting upserts for finishRebuild.
+func (idx *vectorIndex) startRebuild() {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.updated = map[string]indexedEntry{}
+}
+
+// finishRebuild replaces the entries with rebuilt, keeping the entries
+// upserted since startRebuild, and returns the number of entries. A nil
+// rebuilt keeps the current entries, for rebuilds that failed.
+func (idx *vectorIndex) finishRebuild(rebuilt []indexedEntry) int {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	if rebuilt != nil {
+		for _, entry := range idx.updated {
+			rebuilt = upsertEntry(rebuilt, entry)
+		}
+		idx.entries = rebuilt
+	}
+	idx.updated = nil
+	return len(idx.entries)
+}
+
+// Search returns up to limit entries ordered by cosine similarity to vector.
+func (idx *vectorIndex) Search(vector []float32, limit int) []Entry {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	type scored struct {
+		entry Entry
+		score float64
+	}
+	results := make([]scored, 0, len(idx.entries))
+	for _, e := range idx.entries {
+		results = append(results, scored{entry: e.Entry, score: cosineSimilarity(vector, e.Vector)})
+	}
+	sort.SliceStable(results, func(i, j int) bool { return results[i].score > results[j].score })
+
+	entries := []Entry{}
+	for i := 0; i < len(results) && i < limit; i++ {
+		entries = append(entries, results[i].entry)
+	}
+	return entries
+}
+
+func cosineSimilarity(a, b []float32) float64 {
+	if len(a) != len(b) || len(a) == 0 {
+		return 0
+	}
+	var dot, normA, normB float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		normA += float64(a[i]) * float64(a[i])
+		normB += float64(b[i]) * float64(b[i])
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(normA) * math.Sqrt(normB))
+}
+
+func (idx *vectorIndex) load() error {
+	data, err := os.ReadFile(idx.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	return json.Unmarshal(data, &idx.entries)
+}
+
+func (idx *vectorIndex) save() error {
+	// Hold the save lock from encoding to rename, so a slower save can't
+	// overwrite the file with an older snapshot
+	idx.saveMu.Lock()
+	defer idx.saveMu.Unlock()
+
+	idx.mu.RLock()
+	data, err := json.Marshal(idx.entries)
+	idx.mu.RUnlock()
+	if err != nil {
+		return fmt.Errorf("failed to encode index: %w", err)
+	}
+
+	dir := filepath.Dir(idx.path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to save index: %w", err)
+	}
+	tmp, err := os.CreateTemp(dir, "index-*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to save index: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to save index: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to save index: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), idx.path); err != nil {
+		return fmt.Errorf("failed to save index: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package util
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestVectorIndexRebuildKeepsUpserts(t *testing.T) {
+	idx := &vectorIndex{entries: []indexedEntry{{PageID: "a"}, {PageID: "b"}}}
+
+	idx.startRebuild()
+	// Saved while the rebuild was embedding the database
+	idx.Upsert(indexedEntry{PageID: "c", Entry: Entry{Title: "new"}})
+	idx.Upsert(indexedEntry{PageID: "a", Entry: Entry{Title: "edited"}})
+	n := idx.finishRebuild([]indexedEntry{{PageID: "a"}, {PageID: "b"}})
+
+	if n != 3 {
+		t.Fatalf("got %d entries, want 3", n)
+	}
+	titles := map[string]string{}
+	for _, e := range idx.entries {
+		titles[e.PageID] = e.Title
+	}
+	if titles["a"] != "edited" || titles["c"] != "new" {
+		t.Errorf("entries after rebuild = %v", titles)
+	}
+
+	// A failed rebuild keeps the entries and stops collecting upserts
+	idx.startRebuild()
+	idx.finishRebuild(nil)
+	if idx.Len() != 3 || idx.updated != nil {
+		t.Errorf("failed rebuild left %d entries, collecting %v", idx.Len(), idx.updated != nil)
+	}
+}
+
+func TestVectorIndexConcurrentSave(t *testing.T) {
+	dir := t.TempDir()
+	idx := &vectorIndex{path: filepath.Join(dir, "index.json")}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			idx.Upsert(indexedEntry{PageID: fmt.Sprint(i), Vector: []float32{1}})
+			if err := idx.save(); err != nil {
+				t.Errorf("save: %v", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	loaded := &vectorIndex{path: idx.path}
+	if err := loaded.load(); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if loaded.Len() != 20 {
+		t.Errorf("loaded %d entries, want 20", loaded.Len())
+	}
+	files, _ := os.ReadDir(dir)
+	if len(files) != 1 {
+		t.Errorf("left %d files behind, want only the index", len(files))
+	}
+}
@@ -3,16 +3,18 @@ package util
 import (
 	"bufio"
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"os"
+	"regexp"
+	"sort"
 	"strings"
 	"sync"
 	"time"
 
 	mapset "github.com/deckarep/golang-set/v2"
 	"github.com/tmc/langchaingo/llms"
-	"github.com/tmc/langchaingo/llms/ollama"
 )
 
 const (
@@ -23,119 +25,332 @@ const (
 var (
 	GlobalLabels mapset.Set[string]
 	labelsMutex  sync.RWMutex
+
+	llmClient  llms.Model
+	llmOptions []llms.CallOption
 )
 
 func InitLLM() {
+	cfg, err := LoadLLMConfig()
+	if err != nil {
+		log.Fatalf("Error loading LLM config: %v", err)
+	}
+	llmClient, err = NewLLM(cfg)
+	if err != nil {
+		log.Fatalf("Failed to initialize %s model: %v", cfg.Provider, err)
+	}
+	llmOptions = cfg.CallOptions()
+	embedder, err = NewEmbedder(cfg)
+	if err != nil {
+		log.Fatalf("Failed to initialize %s embeddings: %v", cfg.Provider, err)
+	}
+	PrintDebug(fmt.Sprintf("LLM provider: %s, model: %s", cfg.Provider, cfg.Model))
+
 	GlobalLabels = mapset.NewSet[string]()
 	loadLabelsFromFile()
 }
 
-func CallOllama(input string, history []string) (string, error) {
-	llm, err := ollama.New(ollama.WithModel("llama3.1"))
-	if err != nil {
-		log.Printf("Failed to initialize Ollama model: %v", err)
-		return "", err
+// StreamFunc receives the full text generated so far each time the model
+// produces more tokens.
+type StreamFunc func(text string)
+
+// generate runs a single prompt against the configured model.
+func generate(ctx context.Context, prompt string) (string, error) {
+	return llms.GenerateFromSinglePrompt(ctx, llmClient, prompt, llmOptions...)
+}
+
+// generateStream is like generate but reports partial output to stream.
+func generateStream(ctx context.Context, prompt string, stream StreamFunc) (string, error) {
+	if stream == nil {
+		return generate(ctx, prompt)
 	}
+	var partial strings.Builder
+	opts := append(append([]llms.CallOption{}, llmOptions...), llms.WithStreamingFunc(func(ctx context.Context, chunk []byte) error {
+		partial.Write(chunk)
+		stream(partial.String())
+		return nil
+	}))
+	return llms.GenerateFromSinglePrompt(ctx, llmClient, prompt, opts...)
+}
 
-	// First, use LLM to classify the input
-	classificationPrompt := fmt.Sprintf(
-		"Classify the following input as either 'URL' or 'QUERY'. If it's a URL, extract the URL and any user-provided labels that appear after the URL. Labels are any words or symbols following the URL. Return the result strictly in the format 'URL: https://example.com/page, label1, label2' for URLs with labels, or 'URL: https://example.com/page' for URLs without labels. If the input is not a URL, return 'QUERY'. Do not include any additional text. Input: %s",
-		input)
-	classification, err := classifyInput(llm, classificationPrompt)
-	if err != nil {
-		log.Printf("Failed to classify input: %v", err)
-		return "", err
+// CallLLM answers a message, either saving the link it contains or replying to
+// it with the conversation history as context. Replies are streamed to stream
+// when it is not nil. origin is the Slack message, credited on saved pages.
+func CallLLM(input string, history []string, origin Origin, stream StreamFunc) (string, error) {
+	// Parse the message first and only ask the LLM when the intent is unclear
+	intent, link := ParseMessage(input)
+	if intent == IntentAmbiguous {
+		classificationPrompt := fmt.Sprintf(
+			"Classify the following input as either 'URL' or 'QUERY'. If it's a URL, extract the URL and any user-provided labels that appear after the URL. Labels are any words or symbols following the URL. Return the result strictly in the format 'URL: https://example.com/page, label1, label2' for URLs with labels, or 'URL: https://example.com/page' for URLs without labels. If the input is not a URL, return 'QUERY'. Do not include any additional text. Input: %s",
+			input)
+		classification, err := classifyInput(llmClient, classificationPrompt)
+		if err != nil {
+			log.Printf("Failed to classify input: %v", err)
+			return "", err
+		}
+		PrintDebug("classification result: " + classification)
+		intent, link = parseClassification(classification)
 	}
-	PrintDebug("classification result: " + classification)
 	PrintDebug("Global Labels are: " + strings.Join(GlobalLabels.ToSlice(), ", "))
 
-	// Process based on classification
-	if strings.HasPrefix(classification, "URL:") {
-		parts := strings.SplitN(classification, ":", 2)
-		if len(parts) == 2 {
-			urlAndLabels := strings.TrimSpace(parts[1])
-			urlParts := strings.Fields(urlAndLabels)
-			url := urlParts[0]
-			userLabels := strings.Join(urlParts[1:], ", ")
-		
-
-			title, summary, _ := processURL(llm, urlAndLabels)
-			err = AddEntryToDatabase(title, time.Now().Format("2006-01-02"), userLabels, url, summary)
-			if err != nil {
-				log.Printf("Failed to add entry to Notion: %v", err)
-			}
-			return fmt.Sprintf(`I have added the link and label to Notion! 
-			Here's a short summary of what I could find: %s`, summary), nil
-		}
+	if intent == IntentURL {
+		return SaveLink(link, origin)
 	}
 
-	// If not a URL, process as a regular query
+	// If not a URL, answer the query with the relevant saved entries as context
+	history = fitHistory(history, historyBudget)
+	entries := retrieveEntries(input)
+	if library := libraryContext(entries); library != "" {
+		history = append(history, library)
+	}
 	prompt := strings.Join(history, "\n") + fmt.Sprintf("\nUser: %s\nBot:", input)
 
 	ctx := context.Background()
-	completion, err := llms.GenerateFromSinglePrompt(ctx, llm, prompt)
+	completion, err := generateStream(ctx, prompt, stream)
 	if err != nil {
-		log.Printf("Failed to generate response from Ollama: %v", err)
+		log.Printf("Failed to generate response from LLM: %v", err)
 		return "", err
 	}
 
-	history = append(history, fmt.Sprintf("User: %s", input), fmt.Sprintf("Bot: %s", completion))
+	return appendCitations(completion, entries), nil
+}
 
-	return completion, nil
+// SaveLink scrapes and summarizes a link, labels it and adds it to Notion.
+func SaveLink(link LinkRequest, origin Origin) (string, error) {
+	// Dedup and store the canonical form of the link, but scrape the link as
+	// typed since some sites only serve one spelling. Entries saved before
+	// canonicalization may still use the link as typed.
+	canonical := CanonicalURL(link.URL)
+	lookup := []string{canonical}
+	if canonical != link.URL {
+		lookup = append(lookup, link.URL)
+	}
+
+	existing, err := FindEntryByURL(lookup...)
+	if err != nil {
+		log.Printf("Failed to check for duplicate link: %v", err)
+	} else if existing != nil {
+		return duplicateResponse(entryFromPage(*existing), LinkRequest{URL: canonical, Labels: link.Labels}), nil
+	}
+
+	// Don't save an empty entry: it would also count as a duplicate and keep
+	// the link from being saved properly once it can be read.
+	doc, summary, err := processURL(llmClient, link)
+	switch {
+	case errors.Is(err, errRobotsDisallowed):
+		return "Sorry, that site asks bots not to read the page, so I didn't save it.", nil
+	case err != nil && doc.Content() == "":
+		return "Sorry, I couldn't read that link, so I didn't save it. Please try again later.", nil
+	case err != nil:
+		return "Sorry, I couldn't summarize that link, so I didn't save it. Please try again later.", nil
+	}
+	labels, err := saveEntry(doc, canonical, link.Labels, summary, origin)
+	if err != nil {
+		return describeNotionError(err), nil
+	}
+	return fmt.Sprintf(`I have added the link and label to Notion! 
+			Labels: %s
+			Here's a short summary of what I could find: %s`, strings.Join(labels, ", "), summary.Text), nil
+}
+
+// saveEntry labels a summarized document and adds it to Notion, with the
+// summary laid out in the page body, and to the embedding index. It returns
+// the labels the entry was saved with.
+func saveEntry(doc Document, link string, userLabels []string, summary Summary, origin Origin) ([]string, error) {
+	suggested, err := suggestLabels(llmClient, doc.Title, summary.Text, userLabels)
+	if err != nil {
+		log.Printf("Failed to suggest labels: %v", err)
+	}
+	labels := mergeLabels(userLabels, suggested)
+	updateGlobalLabels(labels)
+
+	page, err := AddEntryToDatabase(doc.Title, time.Now().Format("2006-01-02"), strings.Join(labels, ", "), link, summary.Text, doc, entryBlocks(doc, link, summary, origin))
+	if err != nil {
+		log.Printf("Failed to add entry to Notion: %v", err)
+		return labels, err
+	}
+	if err := IndexEntry(string(page.ID), entryFromPage(*page)); err != nil {
+		log.Printf("Failed to index entry: %v", err)
+	}
+	return labels, nil
+}
+
+// duplicateResponse tells the user a link is already saved and offers to add
+// the labels they typed that the entry doesn't have yet.
+func duplicateResponse(entry Entry, link LinkRequest) string {
+	response := fmt.Sprintf("This link is already in Notion: <%s|%s>", entry.PageURL, entry.Title)
+	if len(entry.Labels) > 0 {
+		response += fmt.Sprintf("\nLabels: %s", strings.Join(entry.Labels, ", "))
+	}
+
+	newLabels := missingLabels(entry.Labels, link.Labels)
+	if len(newLabels) > 0 {
+		response += fmt.Sprintf("\nTo add your new labels to it, send `merge %s %s`", link.URL, strings.Join(newLabels, " "))
+	}
+	return response
 }
 
 func classifyInput(llm llms.LLM, prompt string) (string, error) {
 	ctx := context.Background()
-	classification, err := llms.GenerateFromSinglePrompt(ctx, llm, prompt)
+	classification, err := llms.GenerateFromSinglePrompt(ctx, llm, prompt, llmOptions...)
 	if err != nil {
 		return "", err
 	}
 	return strings.TrimSpace(classification), nil
 }
 
-func processURL(llm llms.LLM, urlAndLabels string) (string, string, error) {
-	parts := strings.Fields(urlAndLabels)
-	url := parts[0]
-	userLabels := parts[1:]
-	
-
-	title, content, err := WebScraper(url)
+func processURL(llm llms.LLM, link LinkRequest) (Document, Summary, error) {
+	doc, err := ScrapeDocument(link.URL)
 	if err != nil {
 		log.Printf("Failed to scrape URL: %v", err)
-		return "","", err
+		return Document{}, Summary{}, err
 	}
-	PrintDebug("User provided labels: " + strings.Join(userLabels," "))
-	var prompt string
-	
-	prompt = fmt.Sprintf(`Given the following website content, 
+	log.Printf("Scraped %s via %s", link.URL, doc.Source)
+	PrintDebug("User provided labels: " + strings.Join(link.Labels, " "))
+
+	summary, err := summarizeContent(llm, doc.Content())
+	if err != nil {
+		log.Printf("Failed to generate response for URL analysis: %v", err)
+		return doc, Summary{}, err
+	}
+	return doc, summary, nil
+}
+
+// Summary is the LLM's digest of a saved document.
+type Summary struct {
+	Text      string
+	Takeaways []string
+}
+
+// summarizeContent asks the LLM for a short summary of scraped or uploaded
+// text and its key takeaways.
+func summarizeContent(llm llms.LLM, content string) (Summary, error) {
+	prompt := fmt.Sprintf(`Given the following document content, 
 Content: %s
-Please provide a summary of the content in under 3 sentences. Format your response as follows and do not include any additional text beyond the specified fields or add any markdown support:
-Summary: [Your summary here]`, content)
-	
+Please provide a summary of the content in under 3 sentences, followed by 3 to 5 key takeaways. Format your response as follows and do not include any additional text beyond the specified fields or add any markdown support:
+Summary: [Your summary here]
+Takeaways:
+- [First takeaway]
+- [Second takeaway]`, content)
 
 	ctx := context.Background()
-	completion, err := llms.GenerateFromSinglePrompt(ctx, llm, prompt)
+	completion, err := llms.GenerateFromSinglePrompt(ctx, llm, prompt, llmOptions...)
 	if err != nil {
-		log.Printf("Failed to generate response for URL analysis: %v", err)
-		return "","", err
+		return Summary{}, err
 	}
 
 	summary := extractSummary(completion)
 	
-	PrintDebug("Final summary Here: " + summary)
-	return title,summary, nil
+	PrintDebug(fmt.Sprintf("Final summary Here: %s (%d takeaways)", summary.Text, len(summary.Takeaways)))
+	return summary, nil
+}
+
+func extractSummary(completion string) Summary {
+	summaryPrefix := "Summary:"
+	completion = strings.TrimSpace(completion)
+	if !strings.HasPrefix(completion, summaryPrefix) {
+		return Summary{}
+	}
+	text, takeaways, _ := strings.Cut(strings.TrimPrefix(completion, summaryPrefix), "Takeaways:")
+
+	summary := Summary{Text: strings.TrimSpace(text)}
+	for _, line := range strings.Split(takeaways, "\n") {
+		line = strings.TrimSpace(strings.TrimLeft(strings.TrimSpace(line), "-*•"))
+		if line != "" {
+			summary.Takeaways = append(summary.Takeaways, line)
+		}
+	}
+	return summary
+}
+
+// suggestLabels asks the LLM for up to MaxLabels labels for a saved link,
+// steering it towards the existing vocabulary in GlobalLabels.
+func suggestLabels(llm llms.LLM, title, summary string, userLabels []string) ([]string, error) {
+	vocabulary := GlobalLabels.ToSlice()
+	sort.Strings(vocabulary)
+
+	prompt := fmt.Sprintf(`Pick up to %d short labels that categorize the following link.
+Prefer labels from this existing list whenever one fits: %s
+Only invent a new label if none of the existing ones apply. Labels are lowercase single words or hyphenated-words.
+The user already chose these labels, do not repeat them: %s
+Title: %s
+Summary: %s
+Return only the labels separated by commas, with no additional text.`,
+		MaxLabels, strings.Join(vocabulary, ", "), strings.Join(userLabels, ", "), title, summary)
+
+	ctx := context.Background()
+	completion, err := llms.GenerateFromSinglePrompt(ctx, llm, prompt, llmOptions...)
+	if err != nil {
+		return nil, err
+	}
+	PrintDebug("Suggested labels: " + completion)
+	return parseLabels(completion, vocabulary), nil
 }
 
-func extractSummary(completion string) string {
-	summaryPrefix := "Summary: "
-	if strings.HasPrefix(completion, summaryPrefix) {
-		summary := strings.TrimPrefix(completion, summaryPrefix)
-		return strings.TrimSpace(summary)
+// labelsPrefixRe matches the "Labels" or "Suggested tags" lead-in models put
+// before the list, up to the colon.
+var labelsPrefixRe = regexp.MustCompile(`(?i)\b(labels?|tags?)[\s*_]*$`)
+
+// parseLabels cleans up the LLM's comma separated label list, reusing the
+// existing spelling of labels already in the vocabulary. A "Labels:" lead-in
+// is stripped; other text with a colon is chatter and skipped.
+func parseLabels(completion string, vocabulary []string) []string {
+	known := make(map[string]string, len(vocabulary))
+	for _, label := range vocabulary {
+		known[strings.ToLower(label)] = label
 	}
-	return ""
+
+	labels := []string{}
+	for _, raw := range strings.FieldsFunc(completion, func(r rune) bool { return r == ',' || r == '\n' }) {
+		if before, after, found := strings.Cut(raw, ":"); found {
+			if !labelsPrefixRe.MatchString(before) {
+				continue
+			}
+			raw = after
+		}
+		label := strings.ToLower(strings.Trim(raw, " \t\r-*#.\"'`"))
+		label = strings.Join(strings.Fields(label), "-")
+		if label == "" || strings.Contains(label, ":") {
+			continue
+		}
+		if existing, ok := known[label]; ok {
+			label = existing
+		}
+		labels = append(labels, label)
+		if len(labels) == MaxLabels {
+			break
+		}
+	}
+	return labels
 }
 
+// mergeLabels combines the user's labels with the suggested ones, keeping the
+// user's first and dropping case-insensitive duplicates.
+func mergeLabels(userLabels, suggested []string) []string {
+	seen := mapset.NewThreadUnsafeSet[string]()
+	merged := []string{}
+	for _, label := range append(append([]string{}, userLabels...), suggested...) {
+		if seen.Add(strings.ToLower(label)) {
+			merged = append(merged, label)
+		}
+	}
+	return merged
+}
 
+// missingLabels returns the labels that are not in existing, ignoring case.
+func missingLabels(existing, labels []string) []string {
+	have := mapset.NewThreadUnsafeSet[string]()
+	for _, label := range existing {
+		have.Add(strings.ToLower(label))
+	}
+	missing := []string{}
+	for _, label := range labels {
+		if have.Add(strings.ToLower(label)) {
+			missing = append(missing, label)
+		}
+	}
+	return missing
+}
 
 func updateGlobalLabels(newLabels []string) {
 	labelsMutex.Lock()
@@ -175,10 +390,8 @@ func loadLabelsFromFile() {
 	}
 }
 
+// saveLabelsToFile must be called with labelsMutex held.
 func saveLabelsToFile() {
-	labelsMutex.RLock()
-	defer labelsMutex.RUnlock()
-
 	file, err := os.Create(LabelsFile)
 	if err != nil {
 		log.Printf("Error creating labels file: %v", err)
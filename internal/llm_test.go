@@ -0,0 +1,33 @@
+package util
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseLabels(t *testing.T) {
+	vocabulary := []string{"ML", "rust"}
+	tests := []struct {
+		completion string
+		want       []string
+	}{
+		{"ml, deep learning", []string{"ML", "deep-learning"}},
+		{"Labels: ml, deep learning", []string{"ML", "deep-learning"}},
+		{"labels:ml,nlp", []string{"ML", "nlp"}},
+		{"**Labels:** Rust, wasm", []string{"rust", "wasm"}},
+		{"Suggested tags: compilers", []string{"compilers"}},
+		{"Here are the labels: ml, nlp", []string{"ML", "nlp"}},
+		{"Sure: here you go\n- ml\n- nlp", []string{"ML", "nlp"}},
+		{"- \"transformers\"\n- `attention`.", []string{"transformers", "attention"}},
+		{"a, b, c, d", []string{"a", "b", "c"}},
+		{"", []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.completion, func(t *testing.T) {
+			if got := parseLabels(tt.completion, vocabulary); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseLabels(%q) = %q, want %q", tt.completion, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,258 @@
+package util
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultMemoryDir         = "logs/memory"
+	defaultMemoryMaxMessages = 50
+	defaultMemoryTTL         = 30 * 24 * time.Hour
+
+	// memoryPruneInterval is how often writes also prune the store.
+	memoryPruneInterval = time.Hour
+	// memoryCacheIdle is how long a conversation stays cached after its last
+	// update. Older ones are read from disk again when they come back.
+	memoryCacheIdle = time.Hour
+)
+
+// MemoryStore keeps the chat history of each conversation. Keys identify a
+// conversation (a user, channel or thread) and the history is the list of
+// "User: ..." / "Bot: ..." lines, oldest first.
+type MemoryStore interface {
+	Load(key string) ([]string, error)
+	Append(key string, messages ...string) error
+	// Replace swaps the oldest n messages for the given ones, e.g. a summary.
+	Replace(key string, n int, messages ...string) error
+}
+
+var memory MemoryStore
+
+// conversation is the on-disk format of one conversation.
+type conversation struct {
+	Updated  time.Time `json:"updated"`
+	Messages []string  `json:"messages"`
+}
+
+// fileMemoryStore stores every conversation as a JSON file in dir, loading
+// them on demand and caching the ones already read.
+type fileMemoryStore struct {
+	dir         string
+	maxMessages int
+	ttl         time.Duration
+
+	mu        sync.Mutex
+	cache     map[string]*conversation
+	lastPrune time.Time
+}
+
+// InitMemory opens the conversation store configured by the MEMORY_DIR,
+// MEMORY_MAX_MESSAGES and MEMORY_TTL environment variables.
+func InitMemory() {
+	dir := os.Getenv("MEMORY_DIR")
+	if dir == "" {
+		dir = defaultMemoryDir
+	}
+
+	maxMessages := defaultMemoryMaxMessages
+	if v := os.Getenv("MEMORY_MAX_MESSAGES"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			log.Fatalf("Invalid MEMORY_MAX_MESSAGES %q: %v", v, err)
+		}
+		maxMessages = n
+	}
+
+	ttl := defaultMemoryTTL
+	if v := os.Getenv("MEMORY_TTL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			log.Fatalf("Invalid MEMORY_TTL %q: %v", v, err)
+		}
+		ttl = d
+	}
+
+	store, err := NewFileMemoryStore(dir, maxMessages, ttl)
+	if err != nil {
+		log.Fatalf("Error opening memory store: %v", err)
+	}
+	memory = store
+}
+
+// NewFileMemoryStore creates a store in dir that keeps at most maxMessages
+// lines per conversation and forgets conversations idle for longer than ttl.
+// A zero limit disables it.
+func NewFileMemoryStore(dir string, maxMessages int, ttl time.Duration) (MemoryStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create memory dir: %w", err)
+	}
+	store := &fileMemoryStore{
+		dir:         dir,
+		maxMessages: maxMessages,
+		ttl:         ttl,
+		cache:       make(map[string]*conversation),
+	}
+	store.mu.Lock()
+	store.prune()
+	store.mu.Unlock()
+	return store, nil
+}
+
+func (s *fileMemoryStore) Load(key string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	conv, err := s.get(key)
+	if err != nil {
+		return nil, err
+	}
+	return append([]string(nil), conv.Messages...), nil
+}
+
+func (s *fileMemoryStore) Append(key string, messages ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	conv, err := s.get(key)
+	if err != nil {
+		return err
+	}
+	conv.Messages = append(conv.Messages, messages...)
+	if s.maxMessages > 0 && len(conv.Messages) > s.maxMessages {
+		// Keep the rolling summary, if any, when dropping old messages
+		pinned := []string{}
+		if strings.HasPrefix(conv.Messages[0], historySummaryPrefix) {
+			pinned = conv.Messages[:1]
+		}
+		recent := conv.Messages[len(conv.Messages)-s.maxMessages+len(pinned):]
+		conv.Messages = append(append([]string(nil), pinned...), recent...)
+	}
+	conv.Updated = time.Now()
+	if time.Since(s.lastPrune) > memoryPruneInterval {
+		s.prune()
+	}
+	return s.write(key, conv)
+}
+
+func (s *fileMemoryStore) Replace(key string, n int, messages ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	conv, err := s.get(key)
+	if err != nil {
+		return err
+	}
+	if n > len(conv.Messages) {
+		n = len(conv.Messages)
+	}
+	conv.Messages = append(append([]string(nil), messages...), conv.Messages[n:]...)
+	conv.Updated = time.Now()
+	return s.write(key, conv)
+}
+
+// get returns the cached conversation, reading it from disk on first use.
+// Must be called with s.mu held.
+func (s *fileMemoryStore) get(key string) (*conversation, error) {
+	if conv, ok := s.cache[key]; ok {
+		if !s.expired(conv) {
+			return conv, nil
+		}
+		delete(s.cache, key)
+	}
+
+	conv := &conversation{}
+	data, err := os.ReadFile(s.path(key))
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil:
+		return nil, fmt.Errorf("failed to read conversation %s: %w", key, err)
+	default:
+		if err := json.Unmarshal(data, conv); err != nil {
+			log.Printf("Discarding corrupt conversation %s: %v", key, err)
+			conv = &conversation{}
+		}
+	}
+	if s.expired(conv) {
+		conv = &conversation{}
+	}
+	s.cache[key] = conv
+	return conv, nil
+}
+
+func (s *fileMemoryStore) write(key string, conv *conversation) error {
+	data, err := json.Marshal(conv)
+	if err != nil {
+		return fmt.Errorf("failed to encode conversation %s: %w", key, err)
+	}
+
+	// Write to a temp file first so a crash never leaves a half-written file
+	tmp, err := os.CreateTemp(s.dir, "conv-*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to save conversation %s: %w", key, err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to save conversation %s: %w", key, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to save conversation %s: %w", key, err)
+	}
+	if err := os.Rename(tmp.Name(), s.path(key)); err != nil {
+		return fmt.Errorf("failed to save conversation %s: %w", key, err)
+	}
+	return nil
+}
+
+func (s *fileMemoryStore) expired(conv *conversation) bool {
+	return s.ttl > 0 && !conv.Updated.IsZero() && time.Since(conv.Updated) > s.ttl
+}
+
+// prune drops conversations idle for longer than memoryCacheIdle from the
+// cache and deletes conversation files idle for longer than the TTL. Must be
+// called with s.mu held.
+func (s *fileMemoryStore) prune() {
+	s.lastPrune = time.Now()
+	for key, conv := range s.cache {
+		if time.Since(conv.Updated) > memoryCacheIdle || s.expired(conv) {
+			delete(s.cache, key)
+		}
+	}
+
+	if s.ttl <= 0 {
+		return
+	}
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		log.Printf("Error listing memory dir: %v", err)
+		return
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		if time.Since(info.ModTime()) > s.ttl {
+			if err := os.Remove(filepath.Join(s.dir, entry.Name())); err != nil {
+				log.Printf("Error removing expired conversation: %v", err)
+			}
+		}
+	}
+}
+
+func (s *fileMemoryStore) path(key string) string {
+	return filepath.Join(s.dir, url.PathEscape(key)+".json")
+}
@@ -0,0 +1,104 @@
+package util
+
+import (
+	"encoding/json"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func newTestMemoryStore(t *testing.T, maxMessages int, ttl time.Duration) *fileMemoryStore {
+	t.Helper()
+	store, err := NewFileMemoryStore(t.TempDir(), maxMessages, ttl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store.(*fileMemoryStore)
+}
+
+func loadMessages(t *testing.T, store MemoryStore, key string) []string {
+	t.Helper()
+	messages, err := store.Load(key)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	return messages
+}
+
+func TestFileMemoryStoreAppend(t *testing.T) {
+	store := newTestMemoryStore(t, 4, 0)
+	if err := store.Append("C1", "User: one", "Bot: two", "User: three"); err != nil {
+		t.Fatalf("Append: %v", err)
+	}
+	if err := store.Replace("C1", 2, historySummaryPrefix+"one and two"); err != nil {
+		t.Fatalf("Replace: %v", err)
+	}
+	want := []string{historySummaryPrefix + "one and two", "User: three"}
+	if got := loadMessages(t, store, "C1"); !reflect.DeepEqual(got, want) {
+		t.Errorf("after Replace = %q, want %q", got, want)
+	}
+
+	// Trimming drops the oldest messages but keeps the summary
+	if err := store.Append("C1", "Bot: four", "User: five", "Bot: six"); err != nil {
+		t.Fatalf("Append: %v", err)
+	}
+	want = []string{historySummaryPrefix + "one and two", "Bot: four", "User: five", "Bot: six"}
+	if got := loadMessages(t, store, "C1"); !reflect.DeepEqual(got, want) {
+		t.Errorf("after trimming = %q, want %q", got, want)
+	}
+
+	// Without a summary only the latest messages are kept
+	if err := store.Append("C2", "User: a", "Bot: b", "User: c", "Bot: d", "User: e"); err != nil {
+		t.Fatalf("Append: %v", err)
+	}
+	want = []string{"Bot: b", "User: c", "Bot: d", "User: e"}
+	if got := loadMessages(t, store, "C2"); !reflect.DeepEqual(got, want) {
+		t.Errorf("without summary = %q, want %q", got, want)
+	}
+
+	// A new store reads the conversations back from disk
+	reopened, err := NewFileMemoryStore(store.dir, 4, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := loadMessages(t, reopened, "C2"); !reflect.DeepEqual(got, want) {
+		t.Errorf("reopened = %q, want %q", got, want)
+	}
+}
+
+func TestFileMemoryStoreTTL(t *testing.T) {
+	store := newTestMemoryStore(t, 0, time.Hour)
+
+	// A conversation last updated two hours ago, both on disk and cached
+	old := time.Now().Add(-2 * time.Hour)
+	data, _ := json.Marshal(conversation{Updated: old, Messages: []string{"User: stale"}})
+	if err := os.WriteFile(store.path("old"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(store.path("old"), old, old); err != nil {
+		t.Fatal(err)
+	}
+	store.cache["cached"] = &conversation{Updated: old, Messages: []string{"User: stale"}}
+
+	if got := loadMessages(t, store, "old"); len(got) != 0 {
+		t.Errorf("expired conversation loaded as %q", got)
+	}
+	if got := loadMessages(t, store, "cached"); len(got) != 0 {
+		t.Errorf("expired cached conversation loaded as %q", got)
+	}
+
+	// The next write after the prune interval drops expired and idle
+	// conversations from the cache and from disk
+	store.cache["idle"] = &conversation{Updated: time.Now().Add(-2 * memoryCacheIdle)}
+	store.lastPrune = time.Time{}
+	if err := store.Append("new", "User: hi"); err != nil {
+		t.Fatalf("Append: %v", err)
+	}
+	if _, err := os.Stat(store.path("old")); !os.IsNotExist(err) {
+		t.Errorf("expired conversation file not removed: %v", err)
+	}
+	if _, ok := store.cache["new"]; !ok || len(store.cache) != 1 {
+		t.Errorf("cache after prune has %d conversations, want only the new one", len(store.cache))
+	}
+}
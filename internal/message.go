@@ -0,0 +1,119 @@
+package util
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// Intent is what the user wants BotBot to do with a message.
+type Intent int
+
+const (
+	IntentQuery Intent = iota
+	IntentURL
+	IntentAmbiguous
+)
+
+// LinkRequest is a URL the user asked to save, plus the labels typed after it.
+type LinkRequest struct {
+	URL    string
+	Labels []string
+}
+
+var (
+	// Slack wraps links as <https://example.com> or <https://example.com|shown text>.
+	slackLinkRe = regexp.MustCompile(`<((?:https?|ftp)://[^>|\s]+)(?:\|[^>]*)?>`)
+	// Bare domains such as arxiv.org/abs/1234 that the user typed without a scheme.
+	bareDomainRe = regexp.MustCompile(`(?i)^(?:www\.)?[a-z0-9-]+(?:\.[a-z0-9-]+)*\.[a-z]{2,}(?:/\S*)?$`)
+)
+
+// unwrapSlackLinks replaces Slack link markup with the plain URL.
+func unwrapSlackLinks(text string) string {
+	return slackLinkRe.ReplaceAllString(text, "$1")
+}
+
+// parseURL returns the token as a URL if it is an absolute http(s) link.
+func parseURL(token string) (string, bool) {
+	token = strings.Trim(token, "<>,;\"'()")
+	u, err := url.Parse(token)
+	if err != nil || u.Host == "" {
+		return "", false
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", false
+	}
+	return u.String(), true
+}
+
+// parseBareDomain returns a token like arxiv.org/abs/1234 as an https URL.
+func parseBareDomain(token string) (string, bool) {
+	token = strings.Trim(token, "<>,;\"'()")
+	if !bareDomainRe.MatchString(token) {
+		return "", false
+	}
+	return parseURL("https://" + token)
+}
+
+// splitLabels turns "a, b c,d" into ["a", "b", "c", "d"].
+func splitLabels(tokens []string) []string {
+	labels := []string{}
+	for _, token := range tokens {
+		for _, label := range strings.Split(token, ",") {
+			label = strings.TrimSpace(label)
+			if label != "" {
+				labels = append(labels, label)
+			}
+		}
+	}
+	return labels
+}
+
+// ParseMessage decides deterministically whether a Slack message is a link to
+// save or a question. A message starting with a URL is a link request and the
+// words following it are its labels. A message with no link-like token at all
+// is a query. Anything else (a URL in the middle of a sentence, a bare domain)
+// is ambiguous and left to the LLM.
+func ParseMessage(text string) (Intent, LinkRequest) {
+	fields := strings.Fields(unwrapSlackLinks(text))
+	if len(fields) == 0 {
+		return IntentQuery, LinkRequest{}
+	}
+
+	if link, ok := parseURL(fields[0]); ok {
+		return IntentURL, LinkRequest{URL: link, Labels: splitLabels(fields[1:])}
+	}
+
+	for _, field := range fields {
+		if _, ok := parseURL(field); ok {
+			return IntentAmbiguous, LinkRequest{}
+		}
+		if bareDomainRe.MatchString(strings.Trim(field, ",;\"'()")) {
+			return IntentAmbiguous, LinkRequest{}
+		}
+	}
+	return IntentQuery, LinkRequest{}
+}
+
+// parseClassification reads the LLM's "URL: <link>, label1, label2" or "QUERY"
+// answer. Small models tend to add chatter, so the link is located by parsing
+// the line after "URL:" rather than by splitting on the first colon. They also
+// echo links without a scheme, so a bare domain counts when the line has no
+// http(s) link.
+func parseClassification(classification string) (Intent, LinkRequest) {
+	idx := strings.Index(strings.ToUpper(classification), "URL:")
+	if idx < 0 {
+		return IntentQuery, LinkRequest{}
+	}
+
+	line := strings.SplitN(classification[idx+len("URL:"):], "\n", 2)[0]
+	fields := strings.Fields(unwrapSlackLinks(line))
+	for _, parse := range []func(string) (string, bool){parseURL, parseBareDomain} {
+		for i, field := range fields {
+			if link, ok := parse(field); ok {
+				return IntentURL, LinkRequest{URL: link, Labels: splitLabels(fields[i+1:])}
+			}
+		}
+	}
+	return IntentQuery, LinkRequest{}
+}
@@ -0,0 +1,71 @@
+package util
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseMessage(t *testing.T) {
+	tests := []struct {
+		text   string
+		intent Intent
+		link   LinkRequest
+	}{
+		{"https://example.com/post ml, nlp", IntentURL, LinkRequest{URL: "https://example.com/post", Labels: []string{"ml", "nlp"}}},
+		{"<https://example.com/post|example.com/post> ml,nlp", IntentURL, LinkRequest{URL: "https://example.com/post", Labels: []string{"ml", "nlp"}}},
+		{"<https://example.com/post>", IntentURL, LinkRequest{URL: "https://example.com/post", Labels: []string{}}},
+		{"https://en.wikipedia.org/wiki/Special:Random wiki", IntentURL, LinkRequest{URL: "https://en.wikipedia.org/wiki/Special:Random", Labels: []string{"wiki"}}},
+		{"<https://example.com/search?q=a:b&t=1:30|search> time", IntentURL, LinkRequest{URL: "https://example.com/search?q=a:b&t=1:30", Labels: []string{"time"}}},
+		{"http://localhost:8080/docs", IntentURL, LinkRequest{URL: "http://localhost:8080/docs", Labels: []string{}}},
+
+		{"what do you think of <https://example.com/post|this post>?", IntentAmbiguous, LinkRequest{}},
+		{"save arxiv.org/abs/1706.03762 please", IntentAmbiguous, LinkRequest{}},
+		{"ftp://example.com/file", IntentQuery, LinkRequest{}},
+		{"what is a monad?", IntentQuery, LinkRequest{}},
+		{"   ", IntentQuery, LinkRequest{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			intent, link := ParseMessage(tt.text)
+			if intent != tt.intent || !reflect.DeepEqual(link, tt.link) {
+				t.Errorf("ParseMessage(%q) = %v, %#v, want %v, %#v", tt.text, intent, link, tt.intent, tt.link)
+			}
+		})
+	}
+}
+
+func TestParseClassification(t *testing.T) {
+	tests := []struct {
+		name           string
+		classification string
+		intent         Intent
+		link           LinkRequest
+	}{
+		{"plain", "URL: https://example.com/post, ml, nlp", IntentURL, LinkRequest{URL: "https://example.com/post", Labels: []string{"ml", "nlp"}}},
+		{"lower case", "url: https://example.com/post", IntentURL, LinkRequest{URL: "https://example.com/post", Labels: []string{}}},
+		{"bare domain", "URL: arxiv.org/abs/1706.03762, ml", IntentURL, LinkRequest{URL: "https://arxiv.org/abs/1706.03762", Labels: []string{"ml"}}},
+		{"colon in url", "URL: https://en.wikipedia.org/wiki/Special:Random, wiki", IntentURL, LinkRequest{URL: "https://en.wikipedia.org/wiki/Special:Random", Labels: []string{"wiki"}}},
+		{"slack markup", "URL: <https://example.com/post|example.com/post>, ai", IntentURL, LinkRequest{URL: "https://example.com/post", Labels: []string{"ai"}}},
+		{
+			"chatty",
+			"Sure! Here is my classification of the message:\n\nURL: https://example.com/post, rust, async\n\nLet me know if you need anything else.",
+			IntentURL, LinkRequest{URL: "https://example.com/post", Labels: []string{"rust", "async"}},
+		},
+		{"chatty before the link", "URL: the link is https://example.com/post, go", IntentURL, LinkRequest{URL: "https://example.com/post", Labels: []string{"go"}}},
+		{"link preferred over bare domain", "URL: see example.org at https://example.com/post", IntentURL, LinkRequest{URL: "https://example.com/post", Labels: []string{}}},
+
+		{"query", "QUERY", IntentQuery, LinkRequest{}},
+		{"chatty query", "This message is a QUERY: the user asks about node.js.", IntentQuery, LinkRequest{}},
+		{"url without a link", "URL: none", IntentQuery, LinkRequest{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			intent, link := parseClassification(tt.classification)
+			if intent != tt.intent || !reflect.DeepEqual(link, tt.link) {
+				t.Errorf("parseClassification(%q) = %v, %#v, want %v, %#v", tt.classification, intent, link, tt.intent, tt.link)
+			}
+		})
+	}
+}
@@ -1,11 +1,10 @@
 package util
 
 import (
-	"context"
 	"fmt"
 	"log"
 	"os"
-	"time"
+	"sort"
 	"strings"
 
 	"github.com/jomei/notionapi"
@@ -14,6 +13,10 @@ import (
 var notionClient *notionapi.Client
 var dbID string
 
+// dbProperties are the usable property names of the live database. Fields are
+// only written when the database has their property.
+var dbProperties = map[string]bool{}
+
 func InitNotionClient() {
 	apiKey := os.Getenv("NOTION_API_KEY")
 	parentPageID := os.Getenv("NOTION_PARENT_PAGE_ID")
@@ -22,12 +25,18 @@ func InitNotionClient() {
 		log.Fatalf("API key or parent page ID is not set")
 	}
 
-	token := notionapi.Token(apiKey)
-	notionClient = notionapi.NewClient(token)
+	if err := loadNotionFields(); err != nil {
+		log.Fatalf("Error loading Notion property mapping: %v", err)
+	}
+
+	transport, err := newNotionTransport(os.Getenv("NOTION_BASE_URL"))
+	if err != nil {
+		log.Fatalf("Error creating Notion client: %v", err)
+	}
+	notionClient = newNotionClient(apiKey, transport)
 
 	// Check if the database with the specified title exists under the parent page
 	dbTitle := os.Getenv("NOTION_DB_TITLE")
-	var err error
 	dbID, err = queryDatabase(dbTitle, parentPageID)
 	if err != nil {
 		log.Fatalf("Error checking database: %v", err)
@@ -41,6 +50,10 @@ func InitNotionClient() {
 		}
 	}
 	fmt.Printf("Database ID: %s\n", dbID)
+
+	if err := migrateDatabase(); err != nil {
+		log.Fatalf("Error migrating database schema: %v", err)
+	}
 }
 
 func normalizeID(id string) string {
@@ -48,7 +61,7 @@ func normalizeID(id string) string {
 }
 
 func queryDatabase(dbTitle, parentID string) (string, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := notionContext()
 	defer cancel()
 
 	// Normalize the parent ID for comparison
@@ -81,7 +94,7 @@ func queryDatabase(dbTitle, parentID string) (string, error) {
 }
 
 func createDatabase(dbTitle, parentID string) (string, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := notionContext()
 	defer cancel()
 
 	// Request body to create a database with specified properties
@@ -96,29 +109,7 @@ func createDatabase(dbTitle, parentID string) (string, error) {
 				Text: &notionapi.Text{Content: dbTitle},
 			},
 		},
-		Properties: notionapi.PropertyConfigs{
-			"Name": notionapi.TitlePropertyConfig{
-				Type: notionapi.PropertyConfigTypeTitle,
-			},
-			"Date Created": notionapi.DatePropertyConfig{
-				Type: notionapi.PropertyConfigTypeDate,
-			},
-			"Label Tags": notionapi.MultiSelectPropertyConfig{
-				Type: notionapi.PropertyConfigTypeMultiSelect,
-				MultiSelect: notionapi.Select{
-					Options: []notionapi.Option{
-						{Name: "Tag1"},
-						{Name: "Tag2"},
-					},
-				},
-			},
-			"URL Link": notionapi.URLPropertyConfig{
-				Type: notionapi.PropertyConfigTypeURL,
-			},
-			"Summary": notionapi.RichTextPropertyConfig{
-				Type: notionapi.PropertyConfigTypeRichText,
-			},
-		},
+		Properties: databaseSchema(),
 		IsInline: false,
 	}
 
@@ -131,52 +122,37 @@ func createDatabase(dbTitle, parentID string) (string, error) {
 	return string(newDatabase.ID), nil
 }
 
-func AddEntryToDatabase( name, dateCreated, labelTags, urlLink, summary string) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+// AddEntryToDatabase creates a page for a saved link. Each value is written
+// to the property its field is mapped to; metadata known from doc, such as a
+// paper's authors and DOI, is added when the database has a property for it.
+// body becomes the content of the page, followed by the full text of any
+// property that was too long to store whole.
+func AddEntryToDatabase(name, dateCreated, labelTags, urlLink, summary string, doc Document, body []notionapi.Block) (*notionapi.Page, error) {
+	ctx, cancel := notionContext()
 	defer cancel()
 
-	var dateObject notionapi.Date
-	if err := dateObject.UnmarshalText([]byte(dateCreated)); err != nil {
-		return fmt.Errorf("failed to parse date: %w", err)
+	// Prepare the properties for the new entry
+	properties := notionapi.Properties{}
+	truncated := map[string]string{
+		fieldTitle:     setField(properties, fieldTitle, name),
+		fieldDate:      setField(properties, fieldDate, dateCreated),
+		fieldLabels:    setField(properties, fieldLabels, strings.Split(labelTags, ",")...),
+		fieldURL:       setField(properties, fieldURL, urlLink),
+		fieldSummary:   setField(properties, fieldSummary, summary),
+		fieldAuthors:   setField(properties, fieldAuthors, doc.Authors...),
+		fieldPublished: setField(properties, fieldPublished, doc.Date),
+		fieldUpdated:   setField(properties, fieldUpdated, doc.Updated),
+		fieldCategory:  setField(properties, fieldCategory, doc.Category),
+		fieldSource:    setField(properties, fieldSource, doc.Source),
 	}
-
-	labels := strings.Split(labelTags, ",")
-	multiSelectOptions := make([]notionapi.Option, 0, len(labels))
-	for _, label := range labels {
-		trimmedLabel := strings.TrimSpace(label)
-		if trimmedLabel != "" {
-			multiSelectOptions = append(multiSelectOptions, notionapi.Option{Name: trimmedLabel})
-		}
+	if doc.DOI != "" {
+		truncated[fieldDOI] = setField(properties, fieldDOI, "https://doi.org/"+doc.DOI)
 	}
+	body = append(body, fullTextBlocks(truncated)...)
 
-	// Prepare the properties for the new entry
-	properties := notionapi.Properties{
-		"Name": notionapi.TitleProperty{
-			Title: []notionapi.RichText{
-				{
-					Text: &notionapi.Text{Content: name},
-				},
-			},
-		},
-		"Date Created": notionapi.DateProperty{
-			Date: &notionapi.DateObject{
-				Start: &dateObject, 
-			},
-		},
-		"Label Tags": notionapi.MultiSelectProperty{
-			MultiSelect: multiSelectOptions,
-		},
-		"URL Link": notionapi.URLProperty{
-			URL: urlLink,
-		},
-		"Summary": notionapi.RichTextProperty{
-			RichText: []notionapi.RichText{
-				{
-					Text: &notionapi.Text{Content: summary},
-				},
-			},
-		},
-	}
+	// A page can be created with at most maxBlocksPerRequest blocks, the
+	// rest are appended afterwards
+	first := body[:min(len(body), maxBlocksPerRequest)]
 
 	// Create the new page (entry) in the specified database
 	pageRequest := &notionapi.PageCreateRequest{
@@ -185,13 +161,235 @@ func AddEntryToDatabase( name, dateCreated, labelTags, urlLink, summary string)
 			DatabaseID: notionapi.DatabaseID(dbID),
 		},
 		Properties: properties,
+		Children:   first,
 	}
 
-	_, err := notionClient.Page.Create(ctx, pageRequest)
+	page, err := notionClient.Page.Create(ctx, pageRequest)
 	if err != nil {
-		return fmt.Errorf("failed to add entry to database: %w", err)
+		return nil, fmt.Errorf("failed to add entry to database: %w", err)
+	}
+
+	if err := appendBlocks(notionapi.BlockID(page.ID), body[len(first):]); err != nil {
+		log.Printf("Failed to write the rest of page %s: %v", page.ID, err)
 	}
 
 	fmt.Println("Successfully added entry to database")
+	return page, nil
+}
+
+// fullTextBlocks writes out, under a heading each, the properties that were
+// cut short. Keys are fields, values their full text or "" if they fit.
+func fullTextBlocks(truncated map[string]string) []notionapi.Block {
+	fields := make([]string, 0, len(truncated))
+	for field, text := range truncated {
+		if text != "" {
+			fields = append(fields, field)
+		}
+	}
+	sort.Strings(fields)
+
+	var blocks []notionapi.Block
+	for _, field := range fields {
+		blocks = append(blocks, notionapi.Heading3Block{
+			BasicBlock: basicBlock(notionapi.BlockTypeHeading3),
+			Heading3:   notionapi.Heading{RichText: richText(notionFields[field].Property)},
+		})
+		blocks = append(blocks, paragraphBlocks(truncated[field])...)
+	}
+	return blocks
+}
+
+// appendBlocks adds blocks to the end of a page, maxBlocksPerRequest at a time.
+func appendBlocks(id notionapi.BlockID, blocks []notionapi.Block) error {
+	for len(blocks) > 0 {
+		n := min(len(blocks), maxBlocksPerRequest)
+		ctx, cancel := notionContext()
+		_, err := notionClient.Block.AppendChildren(ctx, id, &notionapi.AppendBlockChildrenRequest{Children: blocks[:n]})
+		cancel()
+		if err != nil {
+			return fmt.Errorf("failed to append blocks: %w", err)
+		}
+		blocks = blocks[n:]
+	}
 	return nil
-}
\ No newline at end of file
+}
+
+// Entry is a saved link read back from the database.
+type Entry struct {
+	Title   string   `json:"title"`
+	Date    string   `json:"date"`
+	Labels  []string `json:"labels"`
+	URL     string   `json:"url"`
+	Summary string   `json:"summary"`
+	PageURL string   `json:"page_url"`
+}
+
+// SearchEntries returns up to limit entries whose name, summary or labels
+// contain any of the words in query, best matches first.
+func SearchEntries(query string, limit int) ([]Entry, error) {
+	ctx, cancel := notionContext()
+	defer cancel()
+
+	keywords := searchKeywords(query)
+	if len(keywords) == 0 {
+		return nil, nil
+	}
+
+	filter := notionapi.OrCompoundFilter{}
+	for _, keyword := range keywords {
+		for _, field := range []string{fieldTitle, fieldSummary, fieldLabels} {
+			if f, ok := fieldFilter(field, keyword, false); ok {
+				filter = append(filter, f)
+			}
+		}
+	}
+
+	response, err := notionClient.Database.Query(ctx, notionapi.DatabaseID(dbID), &notionapi.DatabaseQueryRequest{
+		Filter:   filter,
+		PageSize: 100,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to search database: %w", err)
+	}
+
+	entries := make([]Entry, 0, len(response.Results))
+	for _, page := range response.Results {
+		entries = append(entries, entryFromPage(page))
+	}
+
+	// Rank by how many of the keywords each entry mentions
+	score := func(e Entry) int {
+		text := strings.ToLower(e.Title + " " + e.Summary + " " + strings.Join(e.Labels, " "))
+		n := 0
+		for _, keyword := range keywords {
+			n += strings.Count(text, strings.ToLower(keyword))
+		}
+		return n
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return score(entries[i]) > score(entries[j]) })
+
+	if len(entries) > limit {
+		entries = entries[:limit]
+	}
+	return entries, nil
+}
+
+// FindEntryByURL returns the page saved with any of the given links, or nil
+// when the link has not been saved yet.
+func FindEntryByURL(urls ...string) (*notionapi.Page, error) {
+	ctx, cancel := notionContext()
+	defer cancel()
+
+	filter := notionapi.OrCompoundFilter{}
+	for _, u := range urls {
+		if f, ok := fieldFilter(fieldURL, u, true); ok {
+			filter = append(filter, f)
+		}
+	}
+	if len(filter) == 0 {
+		return nil, nil
+	}
+
+	response, err := notionClient.Database.Query(ctx, notionapi.DatabaseID(dbID), &notionapi.DatabaseQueryRequest{
+		Filter:   filter,
+		PageSize: 1,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up link: %w", err)
+	}
+	if len(response.Results) == 0 {
+		return nil, nil
+	}
+	return &response.Results[0], nil
+}
+
+// AddLabelsToEntry adds labels to an existing page's labels property, keeping
+// the ones it already has. It returns the page as updated.
+func AddLabelsToEntry(page *notionapi.Page, labels []string) (*notionapi.Page, error) {
+	ctx, cancel := notionContext()
+	defer cancel()
+
+	properties := notionapi.Properties{}
+	setField(properties, fieldLabels, mergeLabels(entryFromPage(*page).Labels, labels)...)
+	if len(properties) == 0 {
+		return nil, fmt.Errorf("labels are not mapped to a Notion property")
+	}
+
+	updated, err := notionClient.Page.Update(ctx, notionapi.PageID(page.ID), &notionapi.PageUpdateRequest{
+		Properties: properties,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to update labels: %w", err)
+	}
+	return updated, nil
+}
+
+// allDatabasePages returns every page in the database, following pagination.
+func allDatabasePages() ([]notionapi.Page, error) {
+	var pages []notionapi.Page
+	var cursor notionapi.Cursor
+	for {
+		ctx, cancel := notionContext()
+		response, err := notionClient.Database.Query(ctx, notionapi.DatabaseID(dbID), &notionapi.DatabaseQueryRequest{
+			StartCursor: cursor,
+			PageSize:    100,
+		})
+		cancel()
+		if err != nil {
+			return nil, fmt.Errorf("failed to list database: %w", err)
+		}
+		pages = append(pages, response.Results...)
+		if !response.HasMore {
+			return pages, nil
+		}
+		cursor = response.NextCursor
+	}
+}
+
+// stopwords are common words that say nothing about what to search for.
+// Words shorter than three letters are dropped anyway.
+var stopwords = map[string]bool{
+	"about": true, "all": true, "and": true, "any": true, "are": true, "best": true,
+	"can": true, "could": true, "did": true, "does": true, "find": true, "for": true,
+	"from": true, "get": true, "good": true, "had": true, "has": true, "have": true,
+	"how": true, "into": true, "its": true, "just": true, "learn": true, "like": true,
+	"links": true, "more": true, "most": true, "need": true, "not": true, "our": true,
+	"out": true, "please": true, "saved": true, "should": true, "show": true,
+	"some": true, "something": true, "that": true, "the": true, "their": true,
+	"them": true, "there": true, "these": true, "they": true, "thing": true,
+	"things": true, "this": true, "those": true, "use": true, "using": true,
+	"want": true, "was": true, "way": true, "ways": true, "were": true, "what": true,
+	"when": true, "where": true, "which": true, "who": true, "why": true, "will": true,
+	"with": true, "would": true, "you": true, "your": true,
+}
+
+// searchKeywords drops short and common filler words from a search query.
+func searchKeywords(query string) []string {
+	keywords := []string{}
+	for _, word := range strings.Fields(query) {
+		word = strings.Trim(word, ".,;:!?\"'()")
+		if len([]rune(word)) >= 3 && !stopwords[strings.ToLower(word)] {
+			keywords = append(keywords, word)
+		}
+	}
+	return keywords
+}
+
+func entryFromPage(page notionapi.Page) Entry {
+	return Entry{
+		Title:   fieldValue(page, fieldTitle),
+		Date:    fieldValue(page, fieldDate),
+		Labels:  fieldValues(page, fieldLabels),
+		URL:     fieldValue(page, fieldURL),
+		Summary: fieldValue(page, fieldSummary),
+		PageURL: page.URL,
+	}
+}
+
+func plainText(richText []notionapi.RichText) string {
+	var b strings.Builder
+	for _, rt := range richText {
+		b.WriteString(rt.PlainText)
+	}
+	return b.String()
+}
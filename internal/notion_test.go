@@ -0,0 +1,27 @@
+package util
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSearchKeywords(t *testing.T) {
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"rust async runtimes", []string{"rust", "async", "runtimes"}},
+		{"What is the best way to learn Rust?", []string{"Rust"}},
+		{"papers about (transformers), please", []string{"papers", "transformers"}},
+		{"how do I use it", []string{}},
+		{"Go vs. C", []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			if got := searchKeywords(tt.query); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("searchKeywords(%q) = %q, want %q", tt.query, got, tt.want)
+			}
+		})
+	}
+}
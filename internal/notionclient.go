@@ -0,0 +1,263 @@
+package util
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/jomei/notionapi"
+)
+
+const (
+	// Notion allows an average of three requests per second per integration
+	notionRequestInterval = time.Second / 3
+	notionRetries         = 4
+
+	// notionAttemptTimeout bounds a single request, notionCallTimeout a whole
+	// call including its retries and rate limit waits.
+	notionAttemptTimeout = 20 * time.Second
+	notionCallTimeout    = 2 * time.Minute
+)
+
+// newNotionClient builds the Notion API client on transport, which rate
+// limits and retries its requests.
+func newNotionClient(token string, transport *notionTransport) *notionapi.Client {
+	// The transport does the retrying; WithRetry(1) stops the library from
+	// retrying 429 responses a second time.
+	return notionapi.NewClient(notionapi.Token(token),
+		notionapi.WithHTTPClient(&http.Client{Transport: transport}),
+		notionapi.WithRetry(1),
+	)
+}
+
+// newNotionTransport returns the transport for Notion requests. baseURL, when
+// not empty, replaces https://api.notion.com, e.g. with a fake server in tests.
+func newNotionTransport(baseURL string) (*notionTransport, error) {
+	transport := &notionTransport{
+		base:     http.DefaultTransport,
+		retries:  notionRetries,
+		interval: notionRequestInterval,
+		backoff:  backoff,
+	}
+	if baseURL != "" {
+		u, err := url.Parse(baseURL)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			return nil, fmt.Errorf("invalid Notion base URL %q", baseURL)
+		}
+		transport.baseURL = u
+	}
+	return transport, nil
+}
+
+// notionContext returns the context for one Notion call.
+func notionContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), notionCallTimeout)
+}
+
+// notionTransport spaces requests interval apart and retries rate limited,
+// conflicting and failed requests with backoff, honoring Retry-After.
+// Requests that change data are only retried when Notion certainly didn't
+// apply them, see notionShouldRetry.
+type notionTransport struct {
+	base     http.RoundTripper
+	baseURL  *url.URL
+	retries  int
+	interval time.Duration
+	backoff  func(attempt int) time.Duration
+
+	mutex sync.Mutex
+	next  time.Time
+}
+
+func (t *notionTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		if err := t.wait(req.Context()); err != nil {
+			return nil, err
+		}
+
+		resp, err := t.try(req)
+		if attempt == t.retries || !notionShouldRetry(req, resp, err) {
+			return resp, err
+		}
+
+		delay := t.backoff(attempt)
+		if err != nil {
+			PrintDebug(fmt.Sprintf("Retrying Notion request %s %s in %s: %v", req.Method, req.URL.Path, delay, err))
+		} else {
+			if wait := retryAfter(resp.Header.Get("Retry-After")); wait > 0 {
+				delay = wait
+			}
+			PrintDebug(fmt.Sprintf("Retrying Notion request %s %s in %s: status %d", req.Method, req.URL.Path, delay, resp.StatusCode))
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 4<<10))
+			resp.Body.Close()
+		}
+
+		select {
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		case <-time.After(delay):
+		}
+	}
+}
+
+// try sends one attempt of req, with a fresh copy of its body and its own
+// timeout, which ends when the response body is closed.
+func (t *notionTransport) try(req *http.Request) (*http.Response, error) {
+	ctx, cancel := context.WithTimeout(req.Context(), notionAttemptTimeout)
+	attempt := req.Clone(ctx)
+	if req.Body != nil && req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+		attempt.Body = body
+	}
+	if t.baseURL != nil {
+		attempt.URL.Scheme = t.baseURL.Scheme
+		attempt.URL.Host = t.baseURL.Host
+		attempt.Host = t.baseURL.Host
+	}
+
+	resp, err := t.base.RoundTrip(attempt)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	resp.Body = &cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+// wait blocks until the next request slot.
+func (t *notionTransport) wait(ctx context.Context) error {
+	t.mutex.Lock()
+	now := time.Now()
+	if t.next.Before(now) {
+		t.next = now
+	}
+	delay := t.next.Sub(now)
+	t.next = t.next.Add(t.interval)
+	t.mutex.Unlock()
+
+	if delay == 0 {
+		return nil
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(delay):
+		return nil
+	}
+}
+
+// notionRetryable reports whether Notion asks for the request to be retried:
+// rate limits, conflicting writes and server errors.
+func notionRetryable(code int) bool {
+	return code == http.StatusConflict || retryableStatus(code)
+}
+
+// notionQueryPathRe matches the endpoints that are POST requests but only
+// read data: database queries and search.
+var notionQueryPathRe = regexp.MustCompile(`/v1/(search|databases/[^/]+/query)/?$`)
+
+// notionShouldRetry decides whether to retry a request after an attempt.
+// Reads, database queries and searches included, are retried after any
+// failure. Creating pages and appending blocks may have been applied when
+// the connection drops, the attempt times out or the server fails, and
+// retrying would duplicate them, so writes are only retried on responses
+// that say the request was not processed: rate limits, conflicts and 503
+// Service Unavailable.
+func notionShouldRetry(req *http.Request, resp *http.Response, err error) bool {
+	read := false
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		read = true
+	case http.MethodPost:
+		read = notionQueryPathRe.MatchString(req.URL.Path)
+	}
+	if read {
+		return err != nil || notionRetryable(resp.StatusCode)
+	}
+	if err != nil {
+		return false
+	}
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusConflict, http.StatusServiceUnavailable:
+		return true
+	}
+	return false
+}
+
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c *cancelOnClose) Close() error {
+	err := c.ReadCloser.Close()
+	c.cancel()
+	return err
+}
+
+// =============================== Errors ======================================
+
+// notionErrorKind groups Notion failures by what the user can do about them.
+type notionErrorKind int
+
+const (
+	notionErrorOther notionErrorKind = iota
+	notionErrorValidation
+	notionErrorAuth
+	notionErrorNotFound
+	notionErrorTransient
+)
+
+// classifyNotionError works out why a Notion call failed.
+func classifyNotionError(err error) notionErrorKind {
+	var apiErr *notionapi.Error
+	var rateErr *notionapi.RateLimitedError
+	var netErr net.Error
+	switch {
+	case errors.As(err, &apiErr):
+		switch {
+		case apiErr.Status == http.StatusBadRequest:
+			return notionErrorValidation
+		case apiErr.Status == http.StatusUnauthorized || apiErr.Status == http.StatusForbidden:
+			return notionErrorAuth
+		case apiErr.Status == http.StatusNotFound:
+			return notionErrorNotFound
+		case notionRetryable(apiErr.Status):
+			return notionErrorTransient
+		}
+	case errors.As(err, &rateErr),
+		errors.Is(err, context.DeadlineExceeded),
+		errors.As(err, &netErr):
+		return notionErrorTransient
+	}
+	return notionErrorOther
+}
+
+// describeNotionError turns a Notion failure into a message for Slack.
+func describeNotionError(err error) string {
+	switch classifyNotionError(err) {
+	case notionErrorValidation:
+		var apiErr *notionapi.Error
+		errors.As(err, &apiErr)
+		return fmt.Sprintf("Notion rejected the request: %s. The database may not match the property mapping.", apiErr.Message)
+	case notionErrorAuth:
+		return "I don't have access to the Notion database. Check `NOTION_API_KEY` and that the database is shared with the integration."
+	case notionErrorNotFound:
+		return "Notion couldn't find the database or page. It may have been deleted or unshared."
+	case notionErrorTransient:
+		return "Notion is busy or unreachable right now, please try again in a minute."
+	default:
+		return "Sorry, something went wrong talking to Notion."
+	}
+}
@@ -0,0 +1,200 @@
+package util
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jomei/notionapi"
+)
+
+const testPageJSON = `{"object":"page","id":"59833787-2cf9-4fdf-8782-e53db20768a5","properties":{}}`
+
+// fakeNotion serves the responses in turn, one per request, and repeats the
+// last one. A response of nil drops the connection without answering.
+type fakeNotion struct {
+	responses []func(w http.ResponseWriter)
+	calls     atomic.Int32
+}
+
+func (f *fakeNotion) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	n := int(f.calls.Add(1)) - 1
+	respond := f.responses[min(n, len(f.responses)-1)]
+	if respond == nil {
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err == nil {
+			conn.Close()
+		}
+		return
+	}
+	respond(w)
+}
+
+func notionStatus(status int, code, message string, headers ...string) func(w http.ResponseWriter) {
+	return func(w http.ResponseWriter) {
+		for i := 0; i+1 < len(headers); i += 2 {
+			w.Header().Set(headers[i], headers[i+1])
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		if status == http.StatusOK {
+			fmt.Fprint(w, testPageJSON)
+			return
+		}
+		fmt.Fprintf(w, `{"object":"error","status":%d,"code":%q,"message":%q}`, status, code, message)
+	}
+}
+
+var notionOK = notionStatus(http.StatusOK, "", "")
+
+// newFakeNotionClient returns a client talking to a fake server that answers
+// with responses, retrying without backoff.
+func newFakeNotionClient(t *testing.T, responses ...func(w http.ResponseWriter)) (*notionapi.Client, *fakeNotion) {
+	t.Helper()
+	fake := &fakeNotion{responses: responses}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	transport, err := newNotionTransport(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	transport.interval = 0
+	transport.backoff = func(int) time.Duration { return 0 }
+	return newNotionClient("secret_test", transport), fake
+}
+
+func createTestPage(client *notionapi.Client) error {
+	_, err := client.Page.Create(context.Background(), &notionapi.PageCreateRequest{
+		Parent: notionapi.Parent{Type: notionapi.ParentTypeDatabaseID, DatabaseID: "d9824bdc-8445-4327-be8b-5b47500af6ce"},
+	})
+	return err
+}
+
+func getTestPage(client *notionapi.Client) error {
+	_, err := client.Page.Get(context.Background(), "59833787-2cf9-4fdf-8782-e53db20768a5")
+	return err
+}
+
+func queryTestDatabase(client *notionapi.Client) error {
+	_, err := client.Database.Query(context.Background(), "d9824bdc-8445-4327-be8b-5b47500af6ce", &notionapi.DatabaseQueryRequest{})
+	return err
+}
+
+func searchTest(client *notionapi.Client) error {
+	_, err := client.Search.Do(context.Background(), &notionapi.SearchRequest{Query: "rust"})
+	return err
+}
+
+func TestNotionTransportRetryAfter(t *testing.T) {
+	client, fake := newFakeNotionClient(t,
+		notionStatus(http.StatusTooManyRequests, "rate_limited", "Slow down", "Retry-After", "1"),
+		notionOK,
+	)
+
+	start := time.Now()
+	if err := createTestPage(client); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if calls := fake.calls.Load(); calls != 2 {
+		t.Errorf("got %d requests, want 2", calls)
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("retried after %s, before Retry-After", elapsed)
+	}
+}
+
+func TestNotionTransportRetries(t *testing.T) {
+	unavailable := notionStatus(http.StatusServiceUnavailable, "service_unavailable", "Notion is unavailable")
+	internal := notionStatus(http.StatusInternalServerError, "internal_server_error", "Unexpected error")
+	badGateway := notionStatus(http.StatusBadGateway, "bad_gateway", "Bad gateway")
+	conflict := notionStatus(http.StatusConflict, "conflict_error", "Conflict")
+
+	tests := []struct {
+		name      string
+		request   func(*notionapi.Client) error
+		responses []func(w http.ResponseWriter)
+		calls     int32
+		wantErr   bool
+	}{
+		{"read after server errors", getTestPage, []func(http.ResponseWriter){internal, badGateway, notionOK}, 3, false},
+		{"read after dropped connection", getTestPage, []func(http.ResponseWriter){nil, notionOK}, 2, false},
+		{"read gives up", getTestPage, []func(http.ResponseWriter){internal}, notionRetries + 1, true},
+		{"query after bad gateway", queryTestDatabase, []func(http.ResponseWriter){badGateway, notionOK}, 2, false},
+		{"query after dropped connection", queryTestDatabase, []func(http.ResponseWriter){nil, notionOK}, 2, false},
+		{"search after server error", searchTest, []func(http.ResponseWriter){internal, notionOK}, 2, false},
+		{"write after unavailable", createTestPage, []func(http.ResponseWriter){unavailable, notionOK}, 2, false},
+		{"write after conflict", createTestPage, []func(http.ResponseWriter){conflict, notionOK}, 2, false},
+		{"write not retried after internal error", createTestPage, []func(http.ResponseWriter){internal, notionOK}, 1, true},
+		{"write not retried after bad gateway", createTestPage, []func(http.ResponseWriter){badGateway, notionOK}, 1, true},
+		{"write not retried after dropped connection", createTestPage, []func(http.ResponseWriter){nil, notionOK}, 1, true},
+		{"client error not retried", createTestPage, []func(http.ResponseWriter){notionStatus(http.StatusBadRequest, "validation_error", "Bad"), notionOK}, 1, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, fake := newFakeNotionClient(t, tt.responses...)
+			err := tt.request(client)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if calls := fake.calls.Load(); calls != tt.calls {
+				t.Errorf("got %d requests, want %d", calls, tt.calls)
+			}
+		})
+	}
+}
+
+func TestClassifyNotionError(t *testing.T) {
+	tests := []struct {
+		name     string
+		response func(w http.ResponseWriter)
+		want     notionErrorKind
+	}{
+		{"validation", notionStatus(http.StatusBadRequest, "validation_error", "Title is not a property that exists."), notionErrorValidation},
+		{"unauthorized", notionStatus(http.StatusUnauthorized, "unauthorized", "API token is invalid."), notionErrorAuth},
+		{"restricted", notionStatus(http.StatusForbidden, "restricted_resource", "Insufficient permissions."), notionErrorAuth},
+		{"not found", notionStatus(http.StatusNotFound, "object_not_found", "Could not find database."), notionErrorNotFound},
+		{"rate limited", notionStatus(http.StatusTooManyRequests, "rate_limited", "Slow down", "Retry-After", "0"), notionErrorTransient},
+		{"server error", notionStatus(http.StatusInternalServerError, "internal_server_error", "Unexpected error"), notionErrorTransient},
+		{"dropped connection", nil, notionErrorTransient},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, _ := newFakeNotionClient(t, tt.response)
+			err := createTestPage(client)
+			if err == nil {
+				t.Fatal("Create succeeded")
+			}
+			if got := classifyNotionError(err); got != tt.want {
+				t.Errorf("classifyNotionError(%v) = %d, want %d", err, got, tt.want)
+			}
+		})
+	}
+
+	if got := classifyNotionError(fmt.Errorf("saving page: %w", context.DeadlineExceeded)); got != notionErrorTransient {
+		t.Errorf("deadline exceeded classified as %d", got)
+	}
+	if got := classifyNotionError(&net.OpError{Op: "dial", Err: errors.New("connection refused")}); got != notionErrorTransient {
+		t.Errorf("network error classified as %d", got)
+	}
+	if got := classifyNotionError(errors.New("boom")); got != notionErrorOther {
+		t.Errorf("other error classified as %d", got)
+	}
+}
+
+func TestDescribeNotionError(t *testing.T) {
+	client, _ := newFakeNotionClient(t, notionStatus(http.StatusBadRequest, "validation_error", "Title is not a property that exists."))
+	msg := describeNotionError(createTestPage(client))
+	if !strings.Contains(msg, "Title is not a property that exists.") {
+		t.Errorf("describeNotionError() = %q, want Notion's message", msg)
+	}
+}
@@ -0,0 +1,385 @@
+package util
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"net/url"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+)
+
+const (
+	// Only the first pages are read, that's where the abstract and intro are.
+	pdfMaxPages = 3
+	// Largest PDF we are willing to download.
+	pdfMaxBytes = 32 << 20
+)
+
+// isPDF reports whether a response is a PDF, by Content-Type or, for servers
+// that send a generic type, by the link's extension.
+func isPDF(contentType, link string) bool {
+	if mediaType, _, err := mime.ParseMediaType(contentType); err == nil {
+		switch mediaType {
+		case "application/pdf", "application/x-pdf":
+			return true
+		case "text/html", "application/xhtml+xml":
+			return false
+		}
+	}
+	u, err := url.Parse(link)
+	return err == nil && strings.HasSuffix(strings.ToLower(u.Path), ".pdf")
+}
+
+// readPDF extracts the title and the text of the first pdfMaxPages pages of
+// the PDF in r.
+func readPDF(r io.Reader) (string, string, error) {
+	data, err := io.ReadAll(io.LimitReader(r, pdfMaxBytes+1))
+	if err != nil {
+		return "", "", fmt.Errorf("error reading PDF: %w", err)
+	}
+	if len(data) > pdfMaxBytes {
+		return "", "", fmt.Errorf("PDF is larger than %d bytes", pdfMaxBytes)
+	}
+	title, text, err := ExtractPDFText(bytes.NewReader(data), pdfMaxPages)
+	if err != nil {
+		return "", "", err
+	}
+	return title, truncateText(text, maxContentChars), nil
+}
+
+// ExtractPDFText returns the document title and the text of the first
+// maxPages pages. Text is read from the pages' content streams and decoded
+// with the fonts' ToUnicode maps and encodings where the PDF has them. The
+// title comes from the document info and falls back to the first line of
+// text.
+func ExtractPDFText(rs io.ReadSeeker, maxPages int) (string, string, error) {
+	conf := model.NewDefaultConfiguration()
+	conf.Cmd = model.EXTRACTCONTENT
+
+	ctx, err := api.ReadAndValidate(rs, conf)
+	if err != nil {
+		return "", "", fmt.Errorf("error reading PDF: %w", err)
+	}
+
+	pages := []string{}
+	fonts := pdfFontCache{}
+	for p := 1; p <= ctx.PageCount && p <= maxPages; p++ {
+		page, _, inherited, err := ctx.PageDict(p, false)
+		if err != nil {
+			return "", "", fmt.Errorf("error extracting page %d: %w", p, err)
+		}
+		stream, err := ctx.PageContent(page)
+		if err != nil && err != model.ErrNoContent {
+			return "", "", fmt.Errorf("error extracting page %d: %w", p, err)
+		}
+		if text := contentStreamText(stream, fonts.page(ctx, inherited.Resources)); text != "" {
+			pages = append(pages, text)
+		}
+	}
+	text := strings.Join(pages, "\n\n")
+
+	title := strings.TrimSpace(ctx.Title)
+	if title == "" {
+		title, _, _ = strings.Cut(text, "\n")
+	}
+	return strings.TrimSpace(title), text, nil
+}
+
+// contentStreamText pulls the strings shown by the text operators (Tj, TJ, '
+// and ") out of a page content stream, decoding them with the font selected
+// by Tf from fonts. Text positioning operators that move to a new line become
+// line breaks, and wide gaps inside TJ arrays become spaces.
+func contentStreamText(stream []byte, fonts map[string]*pdfFont) string {
+	var out strings.Builder
+	var operands []string
+	var numbers []float64
+	var name string
+	var font *pdfFont
+	var saved []*pdfFont
+	s := &pdfScanner{data: stream}
+
+	for {
+		tok, kind := s.next()
+		if kind == tokEOF {
+			break
+		}
+		switch kind {
+		case tokString:
+			operands = append(operands, font.decode([]byte(tok)))
+		case tokArrayGap:
+			operands = append(operands, " ")
+		case tokNumber:
+			n, _ := strconv.ParseFloat(tok, 64)
+			numbers = append(numbers, n)
+		case tokName:
+			name = tok
+		case tokOperator:
+			switch tok {
+			case "Tf":
+				font = fonts[name]
+			case "q":
+				saved = append(saved, font)
+			case "Q":
+				if len(saved) > 0 {
+					font = saved[len(saved)-1]
+					saved = saved[:len(saved)-1]
+				}
+			case "Tj", "TJ":
+				out.WriteString(strings.Join(operands, ""))
+			case "'", "\"":
+				out.WriteString("\n" + strings.Join(operands, ""))
+			case "T*", "ET":
+				out.WriteString("\n")
+			case "Td", "TD":
+				// A move without vertical offset continues the same line
+				if len(numbers) >= 2 && numbers[len(numbers)-1] == 0 {
+					out.WriteString(" ")
+				} else {
+					out.WriteString("\n")
+				}
+			case "BI":
+				s.skipInlineImage()
+			}
+			operands = operands[:0]
+			numbers = numbers[:0]
+			name = ""
+		}
+	}
+
+	lines := []string{}
+	for _, line := range strings.Split(out.String(), "\n") {
+		line = strings.Join(strings.Fields(line), " ")
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+type pdfToken int
+
+const (
+	tokEOF pdfToken = iota
+	tokString
+	tokArrayGap
+	tokNumber
+	tokName
+	tokOperator
+	tokOther
+)
+
+// pdfScanner is a minimal tokenizer for PDF content streams. It only
+// understands enough of the syntax to find text strings and operators.
+type pdfScanner struct {
+	data    []byte
+	pos     int
+	inArray bool
+}
+
+func (s *pdfScanner) next() (string, pdfToken) {
+	for s.pos < len(s.data) {
+		c := s.data[s.pos]
+		switch {
+		case isPDFSpace(c):
+			s.pos++
+		case c == '%':
+			for s.pos < len(s.data) && s.data[s.pos] != '\n' && s.data[s.pos] != '\r' {
+				s.pos++
+			}
+		case c == '(':
+			return s.literalString(), tokString
+		case c == '<' && s.pos+1 < len(s.data) && s.data[s.pos+1] == '<':
+			s.pos += 2
+			return "", tokOther
+		case c == '<':
+			return s.hexString(), tokString
+		case c == '>':
+			s.pos++
+			return "", tokOther
+		case c == '[':
+			s.inArray = true
+			s.pos++
+			return "", tokOther
+		case c == ']':
+			s.inArray = false
+			s.pos++
+			return "", tokOther
+		case c == '/':
+			s.pos++
+			return s.word(), tokName
+		case c == '{' || c == '}':
+			s.pos++
+			return "", tokOther
+		default:
+			word := s.word()
+			if word == "" {
+				s.pos++
+				continue
+			}
+			if n, err := strconv.ParseFloat(word, 64); err == nil {
+				// Kerning adjustments in TJ arrays are in thousandths of
+				// an em, a large negative one is a word gap.
+				if s.inArray && n < -200 {
+					return "", tokArrayGap
+				}
+				return word, tokNumber
+			}
+			return word, tokOperator
+		}
+	}
+	return "", tokEOF
+}
+
+func isPDFSpace(c byte) bool {
+	return c == ' ' || c == '\n' || c == '\r' || c == '\t' || c == '\f' || c == 0
+}
+
+func isPDFDelimiter(c byte) bool {
+	return strings.IndexByte("()<>[]{}/%", c) >= 0
+}
+
+func (s *pdfScanner) word() string {
+	start := s.pos
+	for s.pos < len(s.data) && !isPDFSpace(s.data[s.pos]) && !isPDFDelimiter(s.data[s.pos]) {
+		s.pos++
+	}
+	return string(s.data[start:s.pos])
+}
+
+// literalString reads a (...) string, handling nesting and escapes, and
+// returns its raw bytes.
+func (s *pdfScanner) literalString() string {
+	var b []byte
+	depth := 0
+	s.pos++ // opening paren
+	for s.pos < len(s.data) {
+		c := s.data[s.pos]
+		s.pos++
+		switch c {
+		case '(':
+			depth++
+			b = append(b, c)
+		case ')':
+			if depth == 0 {
+				return string(b)
+			}
+			depth--
+			b = append(b, c)
+		case '\\':
+			if s.pos >= len(s.data) {
+				break
+			}
+			e := s.data[s.pos]
+			s.pos++
+			switch e {
+			case 'n':
+				b = append(b, '\n')
+			case 'r':
+				b = append(b, '\r')
+			case 't':
+				b = append(b, '\t')
+			case 'b', 'f':
+			case '\r':
+				if s.pos < len(s.data) && s.data[s.pos] == '\n' {
+					s.pos++
+				}
+			case '\n':
+			default:
+				if e >= '0' && e <= '7' {
+					n := int(e - '0')
+					for i := 0; i < 2 && s.pos < len(s.data) && s.data[s.pos] >= '0' && s.data[s.pos] <= '7'; i++ {
+						n = n*8 + int(s.data[s.pos]-'0')
+						s.pos++
+					}
+					b = append(b, byte(n))
+				} else {
+					b = append(b, e)
+				}
+			}
+		default:
+			b = append(b, c)
+		}
+	}
+	return string(b)
+}
+
+// hexString reads a <...> string and returns its raw bytes.
+func (s *pdfScanner) hexString() string {
+	s.pos++ // opening bracket
+	var digits []byte
+	for s.pos < len(s.data) && s.data[s.pos] != '>' {
+		if c := s.data[s.pos]; !isPDFSpace(c) {
+			digits = append(digits, c)
+		}
+		s.pos++
+	}
+	s.pos++ // closing bracket
+	if len(digits)%2 == 1 {
+		digits = append(digits, '0')
+	}
+
+	b := make([]byte, 0, len(digits)/2)
+	for i := 0; i+1 < len(digits); i += 2 {
+		n, err := strconv.ParseUint(string(digits[i:i+2]), 16, 8)
+		if err != nil {
+			return ""
+		}
+		b = append(b, byte(n))
+	}
+	return string(b)
+}
+
+// skipInlineImage jumps over the binary data of an inline image (BI ... ID
+// data EI).
+func (s *pdfScanner) skipInlineImage() {
+	idx := bytes.Index(s.data[s.pos:], []byte("ID"))
+	if idx < 0 {
+		s.pos = len(s.data)
+		return
+	}
+	s.pos += idx + 2
+	end := bytes.Index(s.data[s.pos:], []byte("EI"))
+	if end < 0 {
+		s.pos = len(s.data)
+		return
+	}
+	s.pos += end + 2
+}
+
+// decodePDFBytes decodes string bytes as UTF-16 when they carry a byte order
+// mark and as Latin-1 otherwise, dropping control characters. It is used for
+// strings shown without a known font.
+func decodePDFBytes(b []byte) string {
+	var out strings.Builder
+	if len(b) >= 2 && b[0] == 0xFE && b[1] == 0xFF {
+		for i := 2; i+1 < len(b); i += 2 {
+			r := rune(b[i])<<8 | rune(b[i+1])
+			if unicode.IsPrint(r) || r == '\n' {
+				out.WriteRune(r)
+			}
+		}
+		return out.String()
+	}
+
+	for _, c := range b {
+		writePDFRune(&out, rune(c))
+	}
+	return out.String()
+}
+
+// writePDFRune writes r if it is printable, turning carriage returns into
+// line breaks and dropping other control characters.
+func writePDFRune(out *strings.Builder, r rune) {
+	switch {
+	case r == '\r':
+		out.WriteRune('\n')
+	case unicode.IsPrint(r) || r == '\n' || r == '\t':
+		out.WriteRune(r)
+	}
+}
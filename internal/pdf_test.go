@@ -0,0 +1,85 @@
+package util
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestExtractPDFText(t *testing.T) {
+	tests := []struct {
+		file  string
+		title string
+		text  string
+	}{
+		{
+			file:  "simple.pdf",
+			title: "Simple Test Document",
+			text:  "A Study of Things\nHello\nWorld\nWord gapkern\n“Quoted” text — with dashes\nTab here",
+		},
+		{
+			// Ligatures on control codes only in the CMR10 font, not in Times
+			file:  "tex-ot1.pdf",
+			title: "Efficient fine-tuning of different floating models",
+			text:  "Efficient fine-tuning of different floating models\nstays a control code",
+		},
+		{
+			file:  "differences.pdf",
+			title: "first flow",
+			text:  "first flow",
+		},
+		{
+			// The second line uses an Identity-H font without a ToUnicode map
+			file:  "identity-h.pdf",
+			title: "Tést ﬁ 中文",
+			text:  "Tést ﬁ 中文",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			f, err := os.Open(filepath.Join("testdata", tt.file))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+
+			title, text, err := ExtractPDFText(f, pdfMaxPages)
+			if err != nil {
+				t.Fatalf("ExtractPDFText: %v", err)
+			}
+			if title != tt.title {
+				t.Errorf("title = %q, want %q", title, tt.title)
+			}
+			if text != tt.text {
+				t.Errorf("text = %q, want %q", text, tt.text)
+			}
+		})
+	}
+}
+
+func TestContentStreamText(t *testing.T) {
+	ot1 := map[string]*pdfFont{"F1": {codes: ot1Ligatures}}
+	tests := []struct {
+		name   string
+		stream string
+		fonts  map[string]*pdfFont
+		want   string
+	}{
+		{"carriage return escape", `BT (Hello\rWorld) Tj ET`, nil, "Hello\nWorld"},
+		{"unknown font", `BT /F9 12 Tf (Hello\rWorld) Tj ET`, ot1, "Hello\nWorld"},
+		{"ot1 ligature", `BT /F1 12 Tf (\014nd) Tj ET`, ot1, "find"},
+		{"font restored by Q", `BT /F2 12 Tf q /F1 12 Tf (\014) Tj Q (\014) Tj ET`, ot1, "fi"},
+		{"same line move", `BT (one) Tj 10 0 Td (two) Tj 0 -12 Td (three) Tj ET`, nil, "one two\nthree"},
+		{"utf-16 string", `BT <FEFF00480069> Tj ET`, nil, "Hi"},
+		{"inline image", "BT (before) Tj ET BI /W 1 /H 1 ID \x00(\x01) EI BT (after) Tj ET", nil, "before\nafter"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := contentStreamText([]byte(tt.stream), tt.fonts); got != tt.want {
+				t.Errorf("contentStreamText() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
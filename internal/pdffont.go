@@ -0,0 +1,372 @@
+package util
+
+import (
+	"strconv"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
+)
+
+// pdfFont is what we need to know about a font to turn the strings it shows
+// into text.
+type pdfFont struct {
+	// toUnicode maps character codes to text, from the font's ToUnicode CMap.
+	toUnicode *pdfCMap
+	// composite fonts (Type0) use multi-byte codes, usually glyph ids that
+	// mean nothing without a ToUnicode map.
+	composite bool
+	// codes overrides the Latin-1 reading of single-byte codes, from the
+	// font's encoding.
+	codes map[byte]string
+}
+
+// decode turns the bytes of a shown string into text. A nil font decodes the
+// bytes as plain PDF text strings.
+func (f *pdfFont) decode(b []byte) string {
+	switch {
+	case f == nil:
+		return decodePDFBytes(b)
+	case f.toUnicode == nil && f.composite:
+		// Glyph ids can't be turned into text without a ToUnicode map
+		return ""
+	case f.toUnicode == nil:
+		var out strings.Builder
+		for _, c := range b {
+			f.writeCode(&out, c)
+		}
+		return out.String()
+	}
+
+	var out strings.Builder
+	for i := 0; i < len(b); {
+		n := f.toUnicode.codeLength(b[i:], f.composite)
+		code := b[i:min(i+n, len(b))]
+		i += n
+		if text, ok := f.toUnicode.chars[string(code)]; ok {
+			for _, r := range text {
+				writePDFRune(&out, r)
+			}
+		} else if len(code) == 1 && !f.composite {
+			f.writeCode(&out, code[0])
+		}
+	}
+	return out.String()
+}
+
+// writeCode writes the text of a single-byte code of a simple font.
+func (f *pdfFont) writeCode(out *strings.Builder, c byte) {
+	if text, ok := f.codes[c]; ok {
+		out.WriteString(text)
+		return
+	}
+	writePDFRune(out, rune(c))
+}
+
+// pdfFontCache holds the fonts already loaded, by object, as pages usually
+// share them.
+type pdfFontCache map[types.IndirectRef]*pdfFont
+
+// page returns the fonts of a page's resources by resource name. Fonts that
+// can't be read are left out and their text decoded as Latin-1.
+func (c pdfFontCache) page(ctx *model.Context, resources types.Dict) map[string]*pdfFont {
+	fonts := map[string]*pdfFont{}
+	o, found := resources.Find("Font")
+	if !found {
+		return fonts
+	}
+	dict, err := ctx.DereferenceDict(o)
+	if err != nil {
+		return fonts
+	}
+
+	for name, o := range dict {
+		ref, isRef := o.(types.IndirectRef)
+		if font, ok := c[ref]; isRef && ok {
+			fonts[name] = font
+			continue
+		}
+		d, err := ctx.DereferenceDict(o)
+		if err != nil || d == nil {
+			continue
+		}
+		font := loadPDFFont(ctx, d)
+		if isRef {
+			c[ref] = font
+		}
+		fonts[name] = font
+	}
+	return fonts
+}
+
+// loadPDFFont reads the ToUnicode map and the encoding of a font dictionary.
+func loadPDFFont(ctx *model.Context, d types.Dict) *pdfFont {
+	font := &pdfFont{}
+	if subtype := d.NameEntry("Subtype"); subtype != nil && *subtype == "Type0" {
+		font.composite = true
+	}
+	if o, found := d.Find("ToUnicode"); found {
+		if sd, _, err := ctx.DereferenceStreamDict(o); err == nil && sd != nil && sd.Decode() == nil {
+			font.toUnicode = parseCMap(sd.Content)
+		}
+	}
+	if font.composite {
+		return font
+	}
+
+	o, found := d.Find("Encoding")
+	if !found {
+		// TeX fonts in the old OT1 encoding put ligatures on control codes
+		if baseFont := d.NameEntry("BaseFont"); baseFont != nil && isOT1Font(*baseFont) {
+			font.codes = ot1Ligatures
+		}
+		return font
+	}
+	o, err := ctx.Dereference(o)
+	if err != nil {
+		return font
+	}
+	switch enc := o.(type) {
+	case types.Name:
+		if enc == "WinAnsiEncoding" {
+			font.codes = winAnsiCodes
+		}
+	case types.Dict:
+		font.codes = map[byte]string{}
+		if base := enc.NameEntry("BaseEncoding"); base != nil && *base == "WinAnsiEncoding" {
+			for c, text := range winAnsiCodes {
+				font.codes[c] = text
+			}
+		}
+		applyDifferences(font.codes, enc.ArrayEntry("Differences"))
+	}
+	return font
+}
+
+// applyDifferences adds the glyphs of an encoding's Differences array to
+// codes. Glyphs whose names we don't know keep their Latin-1 reading.
+func applyDifferences(codes map[byte]string, differences types.Array) {
+	code := 0
+	for _, o := range differences {
+		switch v := o.(type) {
+		case types.Integer:
+			code = int(v)
+		case types.Name:
+			if text := glyphText(string(v)); text != "" && code >= 0 && code < 256 {
+				codes[byte(code)] = text
+			}
+			code++
+		}
+	}
+}
+
+// glyphText returns the text of a glyph name: single letters, uniXXXX names
+// and the punctuation and ligatures common in papers.
+func glyphText(name string) string {
+	if text, ok := glyphNames[name]; ok {
+		return text
+	}
+	if len(name) == 1 {
+		return name
+	}
+	if hex, ok := strings.CutPrefix(name, "uni"); ok && len(hex) == 4 {
+		if n, err := strconv.ParseUint(hex, 16, 16); err == nil {
+			return string(rune(n))
+		}
+	}
+	return ""
+}
+
+var glyphNames = map[string]string{
+	"space": " ", "period": ".", "comma": ",", "colon": ":", "semicolon": ";",
+	"hyphen": "-", "parenleft": "(", "parenright": ")", "slash": "/",
+	"question": "?", "exclam": "!", "quotesingle": "'", "quotedbl": "\"",
+	"zero": "0", "one": "1", "two": "2", "three": "3", "four": "4",
+	"five": "5", "six": "6", "seven": "7", "eight": "8", "nine": "9",
+	"ff": "ff", "fi": "fi", "fl": "fl", "ffi": "ffi", "ffl": "ffl",
+	"quoteleft": "‘", "quoteright": "’", "quotedblleft": "“", "quotedblright": "”",
+	"endash": "–", "emdash": "—", "bullet": "•", "ellipsis": "…", "minus": "−",
+}
+
+// isOT1Font reports whether a font is a Computer Modern text font, which
+// uses the OT1 encoding unless the PDF says otherwise.
+func isOT1Font(baseFont string) bool {
+	// Embedded subsets are named like ABCDEF+CMR10
+	if _, name, ok := strings.Cut(baseFont, "+"); ok {
+		baseFont = name
+	}
+	for _, prefix := range []string{"CMR", "CMBX", "CMTI", "CMSL", "CMSS", "CMCSC", "CMDUNH", "CMFIB", "CMFF"} {
+		if strings.HasPrefix(baseFont, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+var ot1Ligatures = map[byte]string{
+	0x0B: "ff", 0x0C: "fi", 0x0D: "fl", 0x0E: "ffi", 0x0F: "ffl",
+}
+
+// winAnsiCodes are the codes where WinAnsiEncoding differs from Latin-1.
+var winAnsiCodes = map[byte]string{
+	0x80: "€", 0x82: "‚", 0x83: "ƒ", 0x84: "„", 0x85: "…", 0x86: "†",
+	0x87: "‡", 0x88: "ˆ", 0x89: "‰", 0x8A: "Š", 0x8B: "‹", 0x8C: "Œ",
+	0x8E: "Ž", 0x91: "‘", 0x92: "’", 0x93: "“", 0x94: "”", 0x95: "•",
+	0x96: "–", 0x97: "—", 0x98: "˜", 0x99: "™", 0x9A: "š", 0x9B: "›",
+	0x9C: "œ", 0x9E: "ž", 0x9F: "Ÿ",
+}
+
+// ============================= ToUnicode CMaps ===============================
+
+// pdfCMap is a parsed ToUnicode CMap.
+type pdfCMap struct {
+	codespace []codeRange
+	chars     map[string]string // raw code bytes to text
+}
+
+type codeRange struct {
+	low, high []byte
+}
+
+// maxCMapRange bounds how many codes one bfrange entry may expand to.
+const maxCMapRange = 1 << 16
+
+// codeLength returns the length of the code at the start of b, from the
+// codespace ranges, or 2 bytes for composite and 1 for simple fonts when the
+// CMap has none that match.
+func (m *pdfCMap) codeLength(b []byte, composite bool) int {
+	for _, r := range m.codespace {
+		if r.contains(b) {
+			return len(r.low)
+		}
+	}
+	if composite {
+		return 2
+	}
+	return 1
+}
+
+func (r codeRange) contains(b []byte) bool {
+	if len(b) < len(r.low) {
+		return false
+	}
+	for i := range r.low {
+		if b[i] < r.low[i] || b[i] > r.high[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// parseCMap reads the codespace ranges and the bfchar and bfrange mappings
+// of a ToUnicode CMap.
+func parseCMap(data []byte) *pdfCMap {
+	m := &pdfCMap{chars: map[string]string{}}
+	s := &pdfScanner{data: data}
+	var section string
+	var operands, array []string
+	inArray := false
+
+	for {
+		tok, kind := s.next()
+		if kind == tokEOF {
+			break
+		}
+		switch kind {
+		case tokString:
+			if s.inArray {
+				array = append(array, tok)
+			} else {
+				operands = append(operands, tok)
+			}
+		case tokOther:
+			if s.inArray && !inArray {
+				array = nil
+			} else if !s.inArray && inArray && section == "bfrange" && len(operands) == 2 {
+				m.addRange(operands[0], operands[1], array, "")
+				operands = nil
+			}
+			inArray = s.inArray
+		case tokOperator:
+			switch tok {
+			case "begincodespacerange":
+				section = "codespacerange"
+			case "beginbfchar":
+				section = "bfchar"
+			case "beginbfrange":
+				section = "bfrange"
+			case "endcodespacerange", "endbfchar", "endbfrange":
+				section = ""
+			}
+			operands = nil
+		}
+
+		switch {
+		case section == "codespacerange" && len(operands) == 2:
+			if len(operands[0]) == len(operands[1]) && operands[0] != "" {
+				m.codespace = append(m.codespace, codeRange{low: []byte(operands[0]), high: []byte(operands[1])})
+			}
+			operands = nil
+		case section == "bfchar" && len(operands) == 2:
+			m.chars[operands[0]] = utf16Text([]byte(operands[1]))
+			operands = nil
+		case section == "bfrange" && len(operands) == 3:
+			m.addRange(operands[0], operands[1], nil, operands[2])
+			operands = nil
+		}
+	}
+	return m
+}
+
+// addRange maps the codes from low to high either to the strings of array in
+// turn or, without an array, to dest with its last character incremented for
+// each code.
+func (m *pdfCMap) addRange(low, high string, array []string, dest string) {
+	if len(low) != len(high) || low == "" {
+		return
+	}
+	first, last := codeValue(low), codeValue(high)
+	if last < first || last-first >= maxCMapRange {
+		return
+	}
+	units := utf16.Encode([]rune(utf16Text([]byte(dest))))
+	for n := first; n <= last; n++ {
+		code := codeBytes(n, len(low))
+		i := int(n - first)
+		switch {
+		case array != nil && i < len(array):
+			m.chars[code] = utf16Text([]byte(array[i]))
+		case array == nil && len(units) > 0:
+			text := append([]uint16{}, units...)
+			text[len(text)-1] += uint16(i)
+			m.chars[code] = string(utf16.Decode(text))
+		}
+	}
+}
+
+func codeValue(code string) uint64 {
+	var n uint64
+	for i := 0; i < len(code); i++ {
+		n = n<<8 | uint64(code[i])
+	}
+	return n
+}
+
+func codeBytes(n uint64, length int) string {
+	b := make([]byte, length)
+	for i := length - 1; i >= 0; i-- {
+		b[i] = byte(n)
+		n >>= 8
+	}
+	return string(b)
+}
+
+// utf16Text decodes the big-endian UTF-16 text of a CMap destination.
+func utf16Text(b []byte) string {
+	units := make([]uint16, 0, len(b)/2)
+	for i := 0; i+1 < len(b); i += 2 {
+		units = append(units, uint16(b[i])<<8|uint16(b[i+1]))
+	}
+	return string(utf16.Decode(units))
+}
@@ -0,0 +1,147 @@
+package util
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/tmc/langchaingo/embeddings"
+	"github.com/tmc/langchaingo/llms"
+	"github.com/tmc/langchaingo/llms/ollama"
+	"github.com/tmc/langchaingo/llms/openai"
+)
+
+const (
+	ProviderOllama = "ollama"
+	ProviderOpenAI = "openai"
+
+	defaultModel = "llama3.1"
+)
+
+// LLMConfig describes which model backend BotBot talks to. It is read from the
+// environment once at startup by InitLLM.
+type LLMConfig struct {
+	Provider       string
+	BaseURL        string
+	Model          string
+	EmbeddingModel string
+	APIKey         string
+	// Temperature is nil when LLM_TEMPERATURE is not set, so that an
+	// explicit 0 is still passed on.
+	Temperature *float64
+	MaxTokens   int
+}
+
+// LoadLLMConfig reads the LLM_* environment variables, falling back to a local
+// Ollama llama3.1 model when nothing is set.
+func LoadLLMConfig() (LLMConfig, error) {
+	cfg := LLMConfig{
+		Provider: strings.ToLower(strings.TrimSpace(os.Getenv("LLM_PROVIDER"))),
+		BaseURL:  strings.TrimSpace(os.Getenv("LLM_BASE_URL")),
+		Model:    strings.TrimSpace(os.Getenv("LLM_MODEL")),
+		APIKey:   os.Getenv("LLM_API_KEY"),
+
+		EmbeddingModel: strings.TrimSpace(os.Getenv("LLM_EMBEDDING_MODEL")),
+	}
+	if cfg.Provider == "" {
+		cfg.Provider = ProviderOllama
+	}
+	if cfg.Model == "" {
+		cfg.Model = defaultModel
+	}
+
+	if v := os.Getenv("LLM_TEMPERATURE"); v != "" {
+		t, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid LLM_TEMPERATURE %q: %w", v, err)
+		}
+		cfg.Temperature = &t
+	}
+	if v := os.Getenv("LLM_MAX_TOKENS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid LLM_MAX_TOKENS %q: %w", v, err)
+		}
+		if n <= 0 {
+			return cfg, fmt.Errorf("invalid LLM_MAX_TOKENS %q: must be positive", v)
+		}
+		cfg.MaxTokens = n
+	}
+	return cfg, nil
+}
+
+// NewLLM builds the model client for the configured provider. "openai" works
+// with any OpenAI-compatible server (OpenAI, llama.cpp, vLLM, LM Studio...).
+func NewLLM(cfg LLMConfig) (llms.Model, error) {
+	switch cfg.Provider {
+	case ProviderOllama:
+		opts := []ollama.Option{ollama.WithModel(cfg.Model)}
+		if cfg.BaseURL != "" {
+			opts = append(opts, ollama.WithServerURL(cfg.BaseURL))
+		}
+		return ollama.New(opts...)
+	case ProviderOpenAI:
+		// Local OpenAI-compatible servers usually don't check the key, but the
+		// client refuses to start without one.
+		apiKey := cfg.APIKey
+		if apiKey == "" {
+			apiKey = "none"
+		}
+		opts := []openai.Option{openai.WithModel(cfg.Model), openai.WithToken(apiKey)}
+		if cfg.BaseURL != "" {
+			opts = append(opts, openai.WithBaseURL(cfg.BaseURL))
+		}
+		return openai.New(opts...)
+	default:
+		return nil, fmt.Errorf("unknown LLM provider %q", cfg.Provider)
+	}
+}
+
+// NewEmbedder builds the embedding client for the configured provider, using
+// EmbeddingModel when set and the chat model otherwise.
+func NewEmbedder(cfg LLMConfig) (embeddings.Embedder, error) {
+	model := cfg.EmbeddingModel
+	if model == "" {
+		model = cfg.Model
+	}
+
+	var client embeddings.EmbedderClient
+	var err error
+	switch cfg.Provider {
+	case ProviderOllama:
+		opts := []ollama.Option{ollama.WithModel(model)}
+		if cfg.BaseURL != "" {
+			opts = append(opts, ollama.WithServerURL(cfg.BaseURL))
+		}
+		client, err = ollama.New(opts...)
+	case ProviderOpenAI:
+		apiKey := cfg.APIKey
+		if apiKey == "" {
+			apiKey = "none"
+		}
+		opts := []openai.Option{openai.WithModel(cfg.Model), openai.WithEmbeddingModel(model), openai.WithToken(apiKey)}
+		if cfg.BaseURL != "" {
+			opts = append(opts, openai.WithBaseURL(cfg.BaseURL))
+		}
+		client, err = openai.New(opts...)
+	default:
+		return nil, fmt.Errorf("unknown LLM provider %q", cfg.Provider)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return embeddings.NewEmbedder(client)
+}
+
+// CallOptions returns the per-request generation options for the config.
+func (cfg LLMConfig) CallOptions() []llms.CallOption {
+	var opts []llms.CallOption
+	if cfg.Temperature != nil {
+		opts = append(opts, llms.WithTemperature(*cfg.Temperature))
+	}
+	if cfg.MaxTokens > 0 {
+		opts = append(opts, llms.WithMaxTokens(cfg.MaxTokens))
+	}
+	return opts
+}
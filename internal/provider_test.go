@@ -0,0 +1,177 @@
+package util
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/tmc/langchaingo/llms"
+)
+
+func TestLoadLLMConfig(t *testing.T) {
+	t.Setenv("LLM_PROVIDER", "")
+	t.Setenv("LLM_MODEL", "")
+	t.Setenv("LLM_TEMPERATURE", "")
+	t.Setenv("LLM_MAX_TOKENS", "")
+
+	cfg, err := LoadLLMConfig()
+	if err != nil {
+		t.Fatalf("LoadLLMConfig: %v", err)
+	}
+	if cfg.Provider != ProviderOllama || cfg.Model != defaultModel {
+		t.Errorf("defaults = %q %q, want %q %q", cfg.Provider, cfg.Model, ProviderOllama, defaultModel)
+	}
+	if cfg.Temperature != nil || len(cfg.CallOptions()) != 0 {
+		t.Error("unset LLM_TEMPERATURE still sets a temperature")
+	}
+
+	t.Setenv("LLM_TEMPERATURE", "0")
+	cfg, err = LoadLLMConfig()
+	if err != nil {
+		t.Fatalf("LoadLLMConfig: %v", err)
+	}
+	opts := llms.CallOptions{Temperature: 0.8}
+	for _, opt := range cfg.CallOptions() {
+		opt(&opts)
+	}
+	if cfg.Temperature == nil || opts.Temperature != 0 {
+		t.Errorf("LLM_TEMPERATURE=0 not passed on, temperature %v", opts.Temperature)
+	}
+
+	for _, env := range []struct{ name, value string }{
+		{"LLM_TEMPERATURE", "warm"},
+		{"LLM_MAX_TOKENS", "many"},
+		{"LLM_MAX_TOKENS", "0"},
+	} {
+		t.Run(env.name+"="+env.value, func(t *testing.T) {
+			t.Setenv("LLM_TEMPERATURE", "")
+			t.Setenv(env.name, env.value)
+			if _, err := LoadLLMConfig(); err == nil {
+				t.Errorf("LoadLLMConfig accepted %s=%q", env.name, env.value)
+			}
+		})
+	}
+}
+
+func TestOllamaProvider(t *testing.T) {
+	var chat struct {
+		Model   string `json:"model"`
+		Options struct {
+			Temperature float64 `json:"temperature"`
+			NumPredict  int     `json:"num_predict"`
+		} `json:"options"`
+	}
+	var embedModel string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/chat":
+			json.NewDecoder(r.Body).Decode(&chat)
+			fmt.Fprint(w, `{"model":"llama3.1","message":{"role":"assistant","content":"Hello from Ollama"},"done":true}`)
+		case "/api/embeddings":
+			var req struct{ Model string }
+			json.NewDecoder(r.Body).Decode(&req)
+			embedModel = req.Model
+			fmt.Fprint(w, `{"embedding":[0.1,0.2,0.3]}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	temperature := 0.2
+	cfg := LLMConfig{Provider: ProviderOllama, BaseURL: server.URL, Model: "llama3.1", EmbeddingModel: "nomic-embed-text", Temperature: &temperature, MaxTokens: 64}
+	llm, err := NewLLM(cfg)
+	if err != nil {
+		t.Fatalf("NewLLM: %v", err)
+	}
+	reply, err := llms.GenerateFromSinglePrompt(context.Background(), llm, "Hi", cfg.CallOptions()...)
+	if err != nil {
+		t.Fatalf("GenerateFromSinglePrompt: %v", err)
+	}
+	if reply != "Hello from Ollama" {
+		t.Errorf("reply = %q", reply)
+	}
+	if chat.Model != "llama3.1" || chat.Options.Temperature != 0.2 || chat.Options.NumPredict != 64 {
+		t.Errorf("request model %q, temperature %v, num_predict %d", chat.Model, chat.Options.Temperature, chat.Options.NumPredict)
+	}
+
+	embedder, err := NewEmbedder(cfg)
+	if err != nil {
+		t.Fatalf("NewEmbedder: %v", err)
+	}
+	vector, err := embedder.EmbedQuery(context.Background(), "Hi")
+	if err != nil {
+		t.Fatalf("EmbedQuery: %v", err)
+	}
+	if len(vector) != 3 || embedModel != "nomic-embed-text" {
+		t.Errorf("embedding %v from model %q", vector, embedModel)
+	}
+}
+
+func TestOpenAIProvider(t *testing.T) {
+	var chat struct {
+		Model       string  `json:"model"`
+		Temperature float64 `json:"temperature"`
+		MaxTokens   int     `json:"max_tokens"`
+	}
+	var auth, embedModel string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/v1/chat/completions":
+			json.NewDecoder(r.Body).Decode(&chat)
+			fmt.Fprint(w, `{"id":"chatcmpl-1","object":"chat.completion","model":"qwen2.5","choices":[{"index":0,"message":{"role":"assistant","content":"Hello from vLLM"},"finish_reason":"stop"}],"usage":{"prompt_tokens":1,"completion_tokens":3,"total_tokens":4}}`)
+		case "/v1/embeddings":
+			var req struct{ Model string }
+			json.NewDecoder(r.Body).Decode(&req)
+			embedModel = req.Model
+			fmt.Fprint(w, `{"object":"list","data":[{"object":"embedding","index":0,"embedding":[0.1,0.2]}],"model":"bge-m3","usage":{"prompt_tokens":1,"total_tokens":1}}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	temperature := 0.0
+	cfg := LLMConfig{Provider: ProviderOpenAI, BaseURL: server.URL + "/v1", Model: "qwen2.5", EmbeddingModel: "bge-m3", Temperature: &temperature, MaxTokens: 128}
+	llm, err := NewLLM(cfg)
+	if err != nil {
+		t.Fatalf("NewLLM: %v", err)
+	}
+	reply, err := llms.GenerateFromSinglePrompt(context.Background(), llm, "Hi", cfg.CallOptions()...)
+	if err != nil {
+		t.Fatalf("GenerateFromSinglePrompt: %v", err)
+	}
+	if reply != "Hello from vLLM" {
+		t.Errorf("reply = %q", reply)
+	}
+	if chat.Model != "qwen2.5" || chat.Temperature != 0 || chat.MaxTokens != 128 {
+		t.Errorf("request model %q, temperature %v, max_tokens %d", chat.Model, chat.Temperature, chat.MaxTokens)
+	}
+	// Local servers get a placeholder key when none is configured
+	if auth != "Bearer none" {
+		t.Errorf("Authorization = %q", auth)
+	}
+
+	embedder, err := NewEmbedder(cfg)
+	if err != nil {
+		t.Fatalf("NewEmbedder: %v", err)
+	}
+	vector, err := embedder.EmbedQuery(context.Background(), "Hi")
+	if err != nil {
+		t.Fatalf("EmbedQuery: %v", err)
+	}
+	if len(vector) != 2 || embedModel != "bge-m3" {
+		t.Errorf("embedding %v from model %q", vector, embedModel)
+	}
+}
+
+func TestNewLLMUnknownProvider(t *testing.T) {
+	if _, err := NewLLM(LLMConfig{Provider: "bard"}); err == nil {
+		t.Error("NewLLM accepted an unknown provider")
+	}
+}
@@ -0,0 +1,70 @@
+package util
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const retrievalLimit = 3
+
+var citationRe = regexp.MustCompile(`\[(\d+)\]`)
+
+// retrieveEntries looks up the saved Notion entries most relevant to a
+// question, using the embedding index when it has been built and keyword
+// search in Notion otherwise.
+func retrieveEntries(query string) []Entry {
+	if index.Len() > 0 {
+		entries, err := FindEntries(query, retrievalLimit)
+		if err == nil {
+			PrintDebug(fmt.Sprintf("Found %d similar entries for %q", len(entries), query))
+			return entries
+		}
+		log.Printf("Failed to search embedding index: %v", err)
+	}
+
+	entries, err := SearchEntries(query, retrievalLimit)
+	if err != nil {
+		log.Printf("Failed to retrieve saved entries: %v", err)
+		return nil
+	}
+	PrintDebug(fmt.Sprintf("Retrieved %d saved entries for %q", len(entries), query))
+	return entries
+}
+
+// libraryContext renders entries as a numbered list for the prompt, so the
+// model can cite them as [1], [2], ...
+func libraryContext(entries []Entry) string {
+	if len(entries) == 0 {
+		return ""
+	}
+
+	var b strings.Builder
+	b.WriteString("System: These entries from the team's saved reading list may be relevant. ")
+	b.WriteString("Use them when they help answer the user and cite them by number like [1]. Ignore them if they are unrelated.\n")
+	for i, entry := range entries {
+		fmt.Fprintf(&b, "[%d] Title: %s\n    Labels: %s\n    URL: %s\n    Summary: %s\n",
+			i+1, entry.Title, strings.Join(entry.Labels, ", "), entry.URL, entry.Summary)
+	}
+	return strings.TrimRight(b.String(), "\n")
+}
+
+// appendCitations lists the Notion entries the answer cited at its end.
+func appendCitations(answer string, entries []Entry) string {
+	cited := []Entry{}
+	seen := map[int]bool{}
+	for _, match := range citationRe.FindAllStringSubmatch(answer, -1) {
+		n, err := strconv.Atoi(match[1])
+		if err != nil || n < 1 || n > len(entries) || seen[n] {
+			continue
+		}
+		seen[n] = true
+		cited = append(cited, entries[n-1])
+	}
+	if len(cited) == 0 {
+		return answer
+	}
+	return answer + "\n\nSources from Notion:\n" + formatEntries(cited)
+}
@@ -0,0 +1,120 @@
+package util
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/jomei/notionapi"
+)
+
+// Notion API limits. Text lengths are counted in UTF-16 code units, as
+// Notion does.
+const (
+	maxRichTextChars    = 2000 // per rich text element
+	maxRichTextElements = 100  // per property value or block
+	maxBlocksPerRequest = 100  // children per create or append call
+
+	// maxPropertyChars keeps text properties readable in a table cell.
+	// Longer values are cut and written out in full in the page body.
+	maxPropertyChars = 2000
+	truncatedMarker  = " … (full text in page)"
+)
+
+// textLength is the length of s as Notion counts it.
+func textLength(s string) int {
+	n := 0
+	for _, r := range s {
+		n += utf16Len(r)
+	}
+	return n
+}
+
+// utf16Len is the number of UTF-16 code units r takes.
+func utf16Len(r rune) int {
+	if r >= 0x10000 {
+		return 2
+	}
+	return 1
+}
+
+// splitText cuts text into pieces of at most limit characters, ending each
+// piece after whitespace where possible so words stay whole. The pieces
+// concatenate back to text. A character longer than limit gets a piece of
+// its own.
+func splitText(text string, limit int) []string {
+	var pieces []string
+	for text != "" {
+		end, length, lastSpace := 0, 0, -1
+		for end < len(text) {
+			r, size := utf8.DecodeRuneInString(text[end:])
+			if length+utf16Len(r) > limit {
+				break
+			}
+			length += utf16Len(r)
+			end += size
+			if unicode.IsSpace(r) {
+				lastSpace = end
+			}
+		}
+		if end == 0 {
+			_, end = utf8.DecodeRuneInString(text)
+		} else if end < len(text) && lastSpace > 0 {
+			end = lastSpace
+		}
+		pieces = append(pieces, text[:end])
+		text = text[end:]
+	}
+	return pieces
+}
+
+// richText builds rich text elements for text of any length.
+func richText(text string) []notionapi.RichText {
+	pieces := splitText(text, maxRichTextChars)
+	elements := make([]notionapi.RichText, 0, len(pieces))
+	for _, piece := range pieces {
+		elements = append(elements, notionapi.RichText{Text: &notionapi.Text{Content: piece}})
+	}
+	return elements
+}
+
+// propertyRichText builds the rich text of a property value, cutting text
+// longer than maxPropertyChars at a word boundary and adding a marker. It
+// reports whether the text was cut.
+func propertyRichText(text string) ([]notionapi.RichText, bool) {
+	if textLength(text) <= maxPropertyChars {
+		return richText(text), false
+	}
+	cut := splitText(text, maxPropertyChars-textLength(truncatedMarker))[0]
+	return richText(strings.TrimRightFunc(cut, unicode.IsSpace) + truncatedMarker), true
+}
+
+// textBlocks spreads rich text over as many blocks as it needs, newBlock
+// wrapping each batch of elements.
+func textBlocks(elements []notionapi.RichText, newBlock func([]notionapi.RichText) notionapi.Block) []notionapi.Block {
+	var blocks []notionapi.Block
+	for len(elements) > 0 {
+		n := min(len(elements), maxRichTextElements)
+		blocks = append(blocks, newBlock(elements[:n]))
+		elements = elements[n:]
+	}
+	return blocks
+}
+
+// paragraphBlocks writes text as paragraphs, one per blank-line separated
+// paragraph of text.
+func paragraphBlocks(text string) []notionapi.Block {
+	var blocks []notionapi.Block
+	for _, paragraph := range strings.Split(text, "\n\n") {
+		if paragraph = strings.TrimSpace(paragraph); paragraph == "" {
+			continue
+		}
+		blocks = append(blocks, textBlocks(richText(paragraph), func(rt []notionapi.RichText) notionapi.Block {
+			return notionapi.ParagraphBlock{
+				BasicBlock: basicBlock(notionapi.BlockTypeParagraph),
+				Paragraph:  notionapi.Paragraph{RichText: rt},
+			}
+		})...)
+	}
+	return blocks
+}
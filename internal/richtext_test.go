@@ -0,0 +1,101 @@
+package util
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestSplitText(t *testing.T) {
+	tests := []struct {
+		name  string
+		text  string
+		limit int
+		want  []string
+	}{
+		{"fits", "hello world", 20, []string{"hello world"}},
+		{"at word boundaries", "hello world foo", 6, []string{"hello ", "world ", "foo"}},
+		{"long word", "abcdefghij", 4, []string{"abcd", "efgh", "ij"}},
+		{"long word then words", "aaaaaaa bb", 4, []string{"aaaa", "aaa ", "bb"}},
+		// Astral-plane characters count as two, as in UTF-16
+		{"astral", "ab😀cd", 3, []string{"ab", "😀c", "d"}},
+		{"astral only", "😀😀😀", 4, []string{"😀😀", "😀"}},
+		{"astral longer than limit", "😀a", 1, []string{"😀", "a"}},
+		{"accents", "éééé", 2, []string{"éé", "éé"}},
+		{"empty", "", 10, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := splitText(tt.text, tt.limit)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("splitText(%q, %d) = %q, want %q", tt.text, tt.limit, got, tt.want)
+			}
+			if joined := strings.Join(got, ""); joined != tt.text {
+				t.Errorf("pieces join to %q", joined)
+			}
+		})
+	}
+}
+
+func TestPropertyRichText(t *testing.T) {
+	tests := []struct {
+		name      string
+		text      string
+		truncated bool
+		kept      int // characters kept before the marker
+	}{
+		{"short", "A short summary.", false, 0},
+		{"at the limit", strings.Repeat("a", maxPropertyChars), false, 0},
+		// 395 words and their spaces fill 1975 of the 1978 characters left
+		// next to the marker, and the last space is dropped
+		{"words", strings.Repeat("word ", 1000), true, 1974},
+		{"long word", strings.Repeat("a", maxPropertyChars+1), true, 1978},
+		{"astral", strings.Repeat("😀", maxPropertyChars/2+1), true, 1978},
+		{"astral at the limit", strings.Repeat("😀", maxPropertyChars/2), false, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			elements, truncated := propertyRichText(tt.text)
+			if truncated != tt.truncated {
+				t.Errorf("truncated = %v, want %v", truncated, tt.truncated)
+			}
+			var b strings.Builder
+			for _, rt := range elements {
+				if n := textLength(rt.Text.Content); n > maxRichTextChars {
+					t.Errorf("element of %d characters", n)
+				}
+				b.WriteString(rt.Text.Content)
+			}
+			got := b.String()
+
+			if !truncated {
+				if got != tt.text {
+					t.Errorf("text changed to %q", got)
+				}
+				return
+			}
+			if n := textLength(truncatedMarker); n != 22 {
+				t.Fatalf("marker is %d characters, the kept lengths below assume 22", n)
+			}
+			if n := textLength(got); n > maxPropertyChars {
+				t.Errorf("truncated text is %d characters, over %d", n, maxPropertyChars)
+			}
+			kept, ok := strings.CutSuffix(got, truncatedMarker)
+			if !ok {
+				t.Fatalf("no marker at the end of %q", got[max(0, len(got)-40):])
+			}
+			if !strings.HasPrefix(tt.text, kept) || strings.TrimSpace(kept) != kept {
+				t.Errorf("kept %q is not a trimmed prefix of the text", kept[max(0, len(kept)-40):])
+			}
+			if !utf8.ValidString(kept) {
+				t.Error("cut through a character")
+			}
+			if n := textLength(kept); n != tt.kept {
+				t.Errorf("kept %d characters, want %d", n, tt.kept)
+			}
+		})
+	}
+}
@@ -0,0 +1,458 @@
+package util
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jomei/notionapi"
+)
+
+// Logical entry fields, the keys of the property mapping file.
+const (
+	fieldTitle     = "title"
+	fieldDate      = "date"
+	fieldLabels    = "labels"
+	fieldURL       = "url"
+	fieldSummary   = "summary"
+	fieldAuthors   = "authors"
+	fieldPublished = "published"
+	fieldUpdated   = "updated"
+	fieldCategory  = "category"
+	fieldDOI       = "doi"
+	fieldSource    = "source"
+)
+
+// notionField maps a logical field to a database property. An empty Property
+// means the field is not stored.
+type notionField struct {
+	Property string                       `json:"property"`
+	Type     notionapi.PropertyConfigType `json:"type"`
+}
+
+// requiredFields are needed to show and deduplicate saved links, so they
+// can't be turned off.
+var requiredFields = []string{fieldTitle, fieldURL}
+
+// fieldTypes lists the property types each field can be stored as, the
+// default first.
+var fieldTypes = map[string][]notionapi.PropertyConfigType{
+	fieldTitle:     {notionapi.PropertyConfigTypeTitle},
+	fieldDate:      {notionapi.PropertyConfigTypeDate, notionapi.PropertyConfigCreatedTime, notionapi.PropertyConfigTypeRichText},
+	fieldLabels:    {notionapi.PropertyConfigTypeMultiSelect, notionapi.PropertyConfigTypeRichText},
+	fieldURL:       {notionapi.PropertyConfigTypeURL, notionapi.PropertyConfigTypeRichText},
+	fieldSummary:   {notionapi.PropertyConfigTypeRichText},
+	fieldAuthors:   {notionapi.PropertyConfigTypeRichText, notionapi.PropertyConfigTypeMultiSelect},
+	fieldPublished: {notionapi.PropertyConfigTypeDate, notionapi.PropertyConfigTypeRichText},
+	fieldUpdated:   {notionapi.PropertyConfigTypeDate, notionapi.PropertyConfigTypeRichText},
+	fieldCategory:  {notionapi.PropertyConfigTypeSelect, notionapi.PropertyConfigTypeMultiSelect, notionapi.PropertyConfigTypeRichText},
+	fieldDOI:       {notionapi.PropertyConfigTypeURL, notionapi.PropertyConfigTypeRichText},
+	fieldSource:    {notionapi.PropertyConfigTypeSelect, notionapi.PropertyConfigTypeRichText},
+}
+
+// defaultPropertyNames are the column names of databases BotBot creates.
+var defaultPropertyNames = map[string]string{
+	fieldTitle:     "Name",
+	fieldDate:      "Date Created",
+	fieldLabels:    "Label Tags",
+	fieldURL:       "URL Link",
+	fieldSummary:   "Summary",
+	fieldAuthors:   "Authors",
+	fieldPublished: "Published",
+	fieldUpdated:   "Updated",
+	fieldCategory:  "Category",
+	fieldDOI:       "DOI",
+	fieldSource:    "Source",
+}
+
+// notionFields is the property mapping in use.
+var notionFields = defaultNotionFields()
+
+func defaultNotionFields() map[string]notionField {
+	fields := make(map[string]notionField, len(fieldTypes))
+	for field, types := range fieldTypes {
+		fields[field] = notionField{Property: defaultPropertyNames[field], Type: types[0]}
+	}
+	return fields
+}
+
+// notionFieldOverride is one entry of the mapping file. Property is a
+// pointer so that leaving it out, which keeps the default, can be told apart
+// from "property": "", which turns the field off.
+type notionFieldOverride struct {
+	Property *string                      `json:"property"`
+	Type     notionapi.PropertyConfigType `json:"type"`
+}
+
+// loadNotionFields reads the property mapping from NOTION_SCHEMA_FILE
+// (default notion_schema.json). Fields the file leaves out keep their
+// defaults; without a file the defaults are used as is. Unknown keys are
+// rejected so a typo doesn't silently change the mapping.
+func loadNotionFields() error {
+	path := os.Getenv("NOTION_SCHEMA_FILE")
+	if path == "" {
+		path = "notion_schema.json"
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	var overrides map[string]notionFieldOverride
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&overrides); err != nil {
+		return fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	if dec.More() {
+		return fmt.Errorf("failed to parse %s: unexpected data after the mapping", path)
+	}
+
+	fields := defaultNotionFields()
+	for field, override := range overrides {
+		mapping, ok := fields[field]
+		if !ok {
+			return fmt.Errorf("unknown field %q in %s", field, path)
+		}
+		if override.Property != nil {
+			mapping.Property = *override.Property
+		}
+		if override.Type != "" {
+			mapping.Type = override.Type
+		}
+		fields[field] = mapping
+	}
+	if err := validateNotionFields(fields); err != nil {
+		return fmt.Errorf("invalid %s: %w", path, err)
+	}
+
+	notionFields = fields
+	log.Printf("Loaded Notion property mapping from %s", path)
+	return nil
+}
+
+func validateNotionFields(fields map[string]notionField) error {
+	for _, field := range requiredFields {
+		if fields[field].Property == "" {
+			return fmt.Errorf("field %q must be mapped to a property", field)
+		}
+	}
+
+	used := map[string]string{}
+	for field, mapping := range fields {
+		if mapping.Property == "" {
+			continue
+		}
+		if other, ok := used[mapping.Property]; ok {
+			return fmt.Errorf("fields %q and %q both map to property %q", other, field, mapping.Property)
+		}
+		used[mapping.Property] = field
+
+		supported := false
+		for _, t := range fieldTypes[field] {
+			supported = supported || t == mapping.Type
+		}
+		if !supported {
+			return fmt.Errorf("field %q can't be stored as %s, use one of %v", field, mapping.Type, fieldTypes[field])
+		}
+	}
+	return nil
+}
+
+// mappedProperty returns the property a field is written to, or "" when the
+// field isn't mapped or the database doesn't have a usable property for it.
+func mappedProperty(field string) string {
+	name := notionFields[field].Property
+	if name == "" || !dbProperties[name] {
+		return ""
+	}
+	return name
+}
+
+// databaseSchema returns the properties BotBot expects its database to have.
+func databaseSchema() notionapi.PropertyConfigs {
+	schema := notionapi.PropertyConfigs{}
+	for _, mapping := range notionFields {
+		if mapping.Property != "" {
+			schema[mapping.Property] = propertyConfig(mapping.Type)
+		}
+	}
+	return schema
+}
+
+// propertyConfig returns an empty property schema of the given type.
+func propertyConfig(t notionapi.PropertyConfigType) notionapi.PropertyConfig {
+	switch t {
+	case notionapi.PropertyConfigTypeTitle:
+		return notionapi.TitlePropertyConfig{Type: t}
+	case notionapi.PropertyConfigTypeDate:
+		return notionapi.DatePropertyConfig{Type: t}
+	case notionapi.PropertyConfigCreatedTime:
+		return notionapi.CreatedTimePropertyConfig{Type: t}
+	case notionapi.PropertyConfigTypeURL:
+		return notionapi.URLPropertyConfig{Type: t}
+	case notionapi.PropertyConfigTypeSelect:
+		return notionapi.SelectPropertyConfig{Type: t, Select: notionapi.Select{Options: []notionapi.Option{}}}
+	case notionapi.PropertyConfigTypeMultiSelect:
+		return notionapi.MultiSelectPropertyConfig{Type: t, MultiSelect: notionapi.Select{Options: []notionapi.Option{}}}
+	default:
+		return notionapi.RichTextPropertyConfig{Type: notionapi.PropertyConfigTypeRichText}
+	}
+}
+
+// propertyValue converts field values to a property of the given type. List
+// values are comma separated in text properties, which are cut to
+// maxPropertyChars; truncated reports when that happened. ok is false when
+// the value can't or needn't be written, e.g. for created_time.
+func propertyValue(t notionapi.PropertyConfigType, values []string) (property notionapi.Property, truncated, ok bool) {
+	text := strings.Join(values, ", ")
+	switch t {
+	case notionapi.PropertyConfigTypeTitle:
+		rt, truncated := propertyRichText(text)
+		return notionapi.TitleProperty{Title: rt}, truncated, true
+	case notionapi.PropertyConfigTypeURL:
+		return notionapi.URLProperty{URL: values[0]}, false, true
+	case notionapi.PropertyConfigTypeSelect:
+		return notionapi.SelectProperty{Select: notionapi.Option{Name: optionName(values[0])}}, false, true
+	case notionapi.PropertyConfigTypeMultiSelect:
+		options := make([]notionapi.Option, 0, len(values))
+		for _, value := range values {
+			options = append(options, notionapi.Option{Name: optionName(value)})
+		}
+		return notionapi.MultiSelectProperty{MultiSelect: options}, false, true
+	case notionapi.PropertyConfigTypeDate:
+		var date notionapi.Date
+		if err := date.UnmarshalText([]byte(values[0])); err != nil {
+			log.Printf("Ignoring unparsable date %q: %v", values[0], err)
+			return nil, false, false
+		}
+		return notionapi.DateProperty{Date: &notionapi.DateObject{Start: &date}}, false, true
+	case notionapi.PropertyConfigCreatedTime:
+		// Filled in by Notion
+		return nil, false, false
+	default:
+		rt, truncated := propertyRichText(text)
+		return notionapi.RichTextProperty{RichText: rt}, truncated, true
+	}
+}
+
+// optionName makes a value usable as a select option, which can't contain commas.
+func optionName(value string) string {
+	return strings.TrimSpace(strings.ReplaceAll(value, ",", ""))
+}
+
+// setField writes a field's values into properties if the field is mapped
+// and has a value. It returns the full text when the property had to be cut
+// short, so it can be written to the page body instead.
+func setField(properties notionapi.Properties, field string, values ...string) (fullText string) {
+	name := mappedProperty(field)
+	nonEmpty := values[:0:0]
+	for _, value := range values {
+		if strings.TrimSpace(value) != "" {
+			nonEmpty = append(nonEmpty, strings.TrimSpace(value))
+		}
+	}
+	if name == "" || len(nonEmpty) == 0 {
+		return ""
+	}
+	property, truncated, ok := propertyValue(notionFields[field].Type, nonEmpty)
+	if !ok {
+		return ""
+	}
+	properties[name] = property
+	if truncated {
+		return strings.Join(nonEmpty, ", ")
+	}
+	return ""
+}
+
+// fieldValues reads a field back from a page. Lists stored in text
+// properties are split on commas.
+func fieldValues(page notionapi.Page, field string) []string {
+	mapping := notionFields[field]
+	if mapping.Property == "" {
+		return nil
+	}
+	switch p := page.Properties[mapping.Property].(type) {
+	case *notionapi.TitleProperty:
+		return nonEmptyValues(plainText(p.Title))
+	case *notionapi.RichTextProperty:
+		text := plainText(p.RichText)
+		if field == fieldLabels || field == fieldAuthors {
+			return nonEmptyValues(strings.Split(text, ",")...)
+		}
+		return nonEmptyValues(text)
+	case *notionapi.URLProperty:
+		return nonEmptyValues(p.URL)
+	case *notionapi.SelectProperty:
+		return nonEmptyValues(p.Select.Name)
+	case *notionapi.MultiSelectProperty:
+		values := make([]string, 0, len(p.MultiSelect))
+		for _, option := range p.MultiSelect {
+			values = append(values, option.Name)
+		}
+		return values
+	case *notionapi.DateProperty:
+		if p.Date != nil && p.Date.Start != nil {
+			return []string{time.Time(*p.Date.Start).Format("2006-01-02")}
+		}
+	case *notionapi.CreatedTimeProperty:
+		return []string{p.CreatedTime.Format("2006-01-02")}
+	}
+	return nil
+}
+
+// fieldValue is the first value of a field, or "".
+func fieldValue(page notionapi.Page, field string) string {
+	if values := fieldValues(page, field); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+func nonEmptyValues(values ...string) []string {
+	result := []string{}
+	for _, value := range values {
+		if value = strings.TrimSpace(value); value != "" {
+			result = append(result, value)
+		}
+	}
+	return result
+}
+
+// fieldFilter matches pages whose field contains text, or equals it when
+// exact is set. It returns false for fields that can't be filtered by text.
+func fieldFilter(field, text string, exact bool) (notionapi.PropertyFilter, bool) {
+	name := mappedProperty(field)
+	if name == "" {
+		return notionapi.PropertyFilter{}, false
+	}
+	filter := notionapi.PropertyFilter{Property: name}
+	switch notionFields[field].Type {
+	case notionapi.PropertyConfigTypeTitle, notionapi.PropertyConfigTypeRichText, notionapi.PropertyConfigTypeURL:
+		if exact {
+			filter.RichText = &notionapi.TextFilterCondition{Equals: text}
+		} else {
+			filter.RichText = &notionapi.TextFilterCondition{Contains: text}
+		}
+	case notionapi.PropertyConfigTypeMultiSelect:
+		filter.MultiSelect = &notionapi.MultiSelectFilterCondition{Contains: text}
+	case notionapi.PropertyConfigTypeSelect:
+		filter.Select = &notionapi.SelectFilterCondition{Equals: text}
+	default:
+		return notionapi.PropertyFilter{}, false
+	}
+	return filter, true
+}
+
+// schemaConflict is an expected property that the database has in an
+// incompatible form.
+type schemaConflict struct {
+	Name   string
+	Reason string
+}
+
+func (c schemaConflict) String() string {
+	return fmt.Sprintf("%q %s", c.Name, c.Reason)
+}
+
+// migrateDatabase brings an existing database up to date with the mapping.
+// Missing properties are added; existing ones are never changed or removed.
+// Properties of the wrong type are reported and left out of dbProperties so
+// they are not written, unless they are required, in which case it fails.
+func migrateDatabase() error {
+	ctx, cancel := notionContext()
+	defer cancel()
+
+	database, err := notionClient.Database.Get(ctx, notionapi.DatabaseID(dbID))
+	if err != nil {
+		return fmt.Errorf("failed to get database: %w", err)
+	}
+
+	missing, conflicts := diffSchema(databaseSchema(), database.Properties)
+
+	if len(missing) > 0 {
+		names := make([]string, 0, len(missing))
+		for name := range missing {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		log.Printf("Adding missing Notion properties: %s", strings.Join(names, ", "))
+
+		database, err = notionClient.Database.Update(ctx, notionapi.DatabaseID(dbID), &notionapi.DatabaseUpdateRequest{
+			Properties: missing,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to add properties %s: %w", strings.Join(names, ", "), err)
+		}
+	}
+
+	var required []string
+	for _, conflict := range conflicts {
+		log.Printf("Notion property %s; BotBot will not write it", conflict)
+		for _, field := range requiredFields {
+			if conflict.Name == notionFields[field].Property {
+				required = append(required, conflict.String())
+			}
+		}
+	}
+	if len(required) > 0 {
+		return fmt.Errorf("required properties don't match the schema: %s; rename or change them in Notion", strings.Join(required, "; "))
+	}
+
+	skip := make(map[string]bool, len(conflicts))
+	for _, conflict := range conflicts {
+		skip[conflict.Name] = true
+	}
+	for name := range database.Properties {
+		if !skip[name] {
+			dbProperties[name] = true
+		}
+	}
+	return nil
+}
+
+// diffSchema compares the expected properties with the live ones. A database
+// has exactly one title property, so an expected title under a different
+// name is a conflict rather than something that can be added.
+func diffSchema(expected, live notionapi.PropertyConfigs) (notionapi.PropertyConfigs, []schemaConflict) {
+	liveTitle := ""
+	for name, config := range live {
+		if config.GetType() == notionapi.PropertyConfigTypeTitle {
+			liveTitle = name
+		}
+	}
+
+	missing := notionapi.PropertyConfigs{}
+	var conflicts []schemaConflict
+	for name, config := range expected {
+		current, ok := live[name]
+		switch {
+		case ok && current.GetType() != config.GetType():
+			conflicts = append(conflicts, schemaConflict{
+				Name:   name,
+				Reason: fmt.Sprintf("is %s in Notion, expected %s", current.GetType(), config.GetType()),
+			})
+		case ok:
+		case config.GetType() == notionapi.PropertyConfigTypeTitle && liveTitle != "":
+			conflicts = append(conflicts, schemaConflict{
+				Name:   name,
+				Reason: fmt.Sprintf("is missing and the title property is %q", liveTitle),
+			})
+		default:
+			missing[name] = config
+		}
+	}
+
+	sort.Slice(conflicts, func(i, j int) bool { return conflicts[i].Name < conflicts[j].Name })
+	return missing, conflicts
+}
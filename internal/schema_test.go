@@ -0,0 +1,170 @@
+package util
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/jomei/notionapi"
+)
+
+func TestLoadNotionFields(t *testing.T) {
+	defaults := defaultNotionFields()
+	tests := []struct {
+		name    string
+		file    string
+		check   func(t *testing.T, fields map[string]notionField)
+		wantErr string
+	}{
+		{
+			name: "renamed property",
+			file: `{"summary": {"property": "Notes", "type": "rich_text"}}`,
+			check: func(t *testing.T, fields map[string]notionField) {
+				if got := fields[fieldSummary]; got.Property != "Notes" || got.Type != notionapi.PropertyConfigTypeRichText {
+					t.Errorf("summary = %+v", got)
+				}
+			},
+		},
+		{
+			name: "type only keeps the property name",
+			file: `{"labels": {"type": "rich_text"}}`,
+			check: func(t *testing.T, fields map[string]notionField) {
+				if got := fields[fieldLabels]; got.Property != defaults[fieldLabels].Property || got.Type != notionapi.PropertyConfigTypeRichText {
+					t.Errorf("labels = %+v", got)
+				}
+			},
+		},
+		{
+			name: "property only keeps the type",
+			file: `{"category": {"property": "Area"}}`,
+			check: func(t *testing.T, fields map[string]notionField) {
+				if got := fields[fieldCategory]; got.Property != "Area" || got.Type != defaults[fieldCategory].Type {
+					t.Errorf("category = %+v", got)
+				}
+			},
+		},
+		{
+			name: "explicit empty property turns the field off",
+			file: `{"doi": {"property": ""}}`,
+			check: func(t *testing.T, fields map[string]notionField) {
+				if got := fields[fieldDOI]; got.Property != "" {
+					t.Errorf("doi = %+v, want it off", got)
+				}
+				if got := fields[fieldTitle]; got != defaults[fieldTitle] {
+					t.Errorf("title = %+v, want the default", got)
+				}
+			},
+		},
+		{name: "misspelled key", file: `{"summary": {"name": "Notes"}}`, wantErr: `unknown field "name"`},
+		{name: "unknown field", file: `{"abstract": {"property": "Abstract"}}`, wantErr: `unknown field "abstract"`},
+		{name: "required field off", file: `{"url": {"property": ""}}`, wantErr: `field "url" must be mapped`},
+		{name: "unsupported type", file: `{"summary": {"type": "number"}}`, wantErr: `field "summary" can't be stored as number`},
+		{name: "duplicate property", file: `{"summary": {"property": "Name"}}`, wantErr: `both map to property "Name"`},
+		{name: "trailing data", file: `{} {}`, wantErr: "unexpected data"},
+	}
+
+	defer func(fields map[string]notionField) { notionFields = fields }(notionFields)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "notion_schema.json")
+			if err := os.WriteFile(path, []byte(tt.file), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			t.Setenv("NOTION_SCHEMA_FILE", path)
+			notionFields = defaultNotionFields()
+
+			err := loadNotionFields()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("loadNotionFields() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("loadNotionFields: %v", err)
+			}
+			tt.check(t, notionFields)
+		})
+	}
+}
+
+func TestLoadNotionFieldsExample(t *testing.T) {
+	defer func(fields map[string]notionField) { notionFields = fields }(notionFields)
+	t.Setenv("NOTION_SCHEMA_FILE", filepath.Join("..", "notion_schema.example.json"))
+	if err := loadNotionFields(); err != nil {
+		t.Fatalf("loadNotionFields: %v", err)
+	}
+	for field, want := range defaultNotionFields() {
+		if got := notionFields[field]; got != want {
+			t.Errorf("%s = %+v, want %+v", field, got, want)
+		}
+	}
+}
+
+func TestDiffSchema(t *testing.T) {
+	title := notionapi.TitlePropertyConfig{Type: notionapi.PropertyConfigTypeTitle}
+	text := notionapi.RichTextPropertyConfig{Type: notionapi.PropertyConfigTypeRichText}
+	link := notionapi.URLPropertyConfig{Type: notionapi.PropertyConfigTypeURL}
+	labels := notionapi.MultiSelectPropertyConfig{Type: notionapi.PropertyConfigTypeMultiSelect}
+	expected := notionapi.PropertyConfigs{"Name": title, "URL": link, "Labels": labels, "Summary": text}
+
+	tests := []struct {
+		name      string
+		live      notionapi.PropertyConfigs
+		missing   []string
+		conflicts []schemaConflict
+	}{
+		{
+			name: "up to date",
+			live: notionapi.PropertyConfigs{"Name": title, "URL": link, "Labels": labels, "Summary": text, "Extra": text},
+		},
+		{
+			name:    "missing properties",
+			live:    notionapi.PropertyConfigs{"Name": title, "Labels": labels},
+			missing: []string{"Summary", "URL"},
+		},
+		{
+			name:      "type conflict",
+			live:      notionapi.PropertyConfigs{"Name": title, "URL": link, "Labels": text, "Summary": text},
+			conflicts: []schemaConflict{{Name: "Labels", Reason: "is rich_text in Notion, expected multi_select"}},
+		},
+		{
+			name:    "title under a different name",
+			live:    notionapi.PropertyConfigs{"Title": title, "URL": link},
+			missing: []string{"Labels", "Summary"},
+			conflicts: []schemaConflict{
+				{Name: "Name", Reason: `is missing and the title property is "Title"`},
+			},
+		},
+		{
+			name: "title name taken by another type",
+			live: notionapi.PropertyConfigs{"Title": title, "Name": text, "URL": link, "Labels": labels, "Summary": text},
+			conflicts: []schemaConflict{
+				{Name: "Name", Reason: "is rich_text in Notion, expected title"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			missing, conflicts := diffSchema(expected, tt.live)
+			var names []string
+			for name, config := range missing {
+				if !reflect.DeepEqual(config, expected[name]) {
+					t.Errorf("missing %q has config %#v, want %#v", name, config, expected[name])
+				}
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			if !reflect.DeepEqual(names, tt.missing) {
+				t.Errorf("missing = %q, want %q", names, tt.missing)
+			}
+			if !reflect.DeepEqual(conflicts, tt.conflicts) {
+				t.Errorf("conflicts = %v, want %v", conflicts, tt.conflicts)
+			}
+		})
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"os"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/slack-go/slack"
 	"github.com/slack-go/slack/slackevents"
@@ -16,12 +17,15 @@ import (
 var (
 	client            *slack.Client
 	socketClient      *socketmode.Client
-	conversationHistory = make(map[string][]string)
 	thinkingEmoji     = "one-sec-cooking"
+	streamPlaceholder = "_thinking..._"
 	botID             string
 	once              sync.Once
 )
 
+// streamUpdateInterval throttles chat.update calls while an answer streams in.
+const streamUpdateInterval = time.Second
+
 
 
 
@@ -49,7 +53,10 @@ func SetBotID(id string) {
 	})
 }
 
-// RunSlackServer starts handling Slack events via Socket Mode.
+// RunSlackServer starts handling Slack events via Socket Mode. Events are
+// acknowledged right away and handled in their own goroutines, so a slow
+// scrape or LLM call doesn't hold up other messages or make Slack time out
+// and redeliver them.
 func RunSlackServer() error {
 	go func() {
 		for evt := range socketClient.Events {
@@ -60,11 +67,26 @@ func RunSlackServer() error {
 
 				if eventsAPIEvent.Type == slackevents.CallbackEvent {
 					innerEvent := eventsAPIEvent.InnerEvent
+					files := eventFiles(evt.Request.Payload)
 					switch ev := innerEvent.Data.(type) {
 					case *slackevents.AppMentionEvent:
-						HandleAppMentionEvent(client, ev)
+						go HandleAppMentionEvent(client, ev, files)
+					case *slackevents.MessageEvent:
+						go HandleDirectMessageEvent(client, ev, files)
 					}
 				}
+
+			case socketmode.EventTypeSlashCommand:
+				cmd, ok := evt.Data.(slack.SlashCommand)
+				if !ok {
+					continue
+				}
+				// Slash commands must be acknowledged within 3 seconds
+				socketClient.Ack(*evt.Request, map[string]interface{}{
+					"response_type": slack.ResponseTypeEphemeral,
+					"text":          "_working on it..._",
+				})
+				go HandleSlashCommand(cmd)
 			}
 		}
 	}()
@@ -72,13 +94,145 @@ func RunSlackServer() error {
 	return socketClient.Run()
 }
 
+// incomingMessage is a message addressed to BotBot, either a mention in a
+// channel or a direct message.
+type incomingMessage struct {
+	User            string
+	Channel         string
+	Timestamp       string
+	ThreadTimestamp string
+	Text            string
+	Files           []slack.File
+	Direct          bool
+}
+
+// Origin is the Slack message a request came from. ChannelName is only set
+// when Slack already told us, as it does for slash commands.
+type Origin struct {
+	User        string
+	Channel     string
+	ChannelName string
+	Timestamp   string
+}
+
+// Describe looks up the user's display name, "#channel" (or "a direct
+// message") and a permalink to the message. Lookups that fail fall back to
+// the raw IDs, and the permalink is left empty.
+func (o Origin) Describe() (user, channel, permalink string) {
+	user, channel = o.User, o.ChannelName
+	if client != nil {
+		if info, err := client.GetUserInfo(o.User); err != nil {
+			log.Printf("Failed to look up user %s: %v", o.User, err)
+		} else if info.Profile.DisplayName != "" {
+			user = info.Profile.DisplayName
+		} else {
+			user = info.Name
+		}
+
+		if channel == "" && o.Channel != "" {
+			info, err := client.GetConversationInfo(&slack.GetConversationInfoInput{ChannelID: o.Channel})
+			if err != nil {
+				log.Printf("Failed to look up channel %s: %v", o.Channel, err)
+			} else if info.IsIM {
+				channel = "directmessage"
+			} else {
+				channel = info.Name
+			}
+		}
+
+		if o.Timestamp != "" {
+			link, err := client.GetPermalink(&slack.PermalinkParameters{Channel: o.Channel, Ts: o.Timestamp})
+			if err != nil {
+				log.Printf("Failed to get message permalink: %v", err)
+			}
+			permalink = link
+		}
+	}
+
+	switch channel {
+	case "":
+	case "directmessage":
+		channel = "a direct message"
+	default:
+		channel = "#" + channel
+	}
+	return user, channel, permalink
+}
+
 // HandleAppMentionEvent processes the AppMentionEvent and generates a response.
-func HandleAppMentionEvent(client *slack.Client, event *slackevents.AppMentionEvent) {
-	userID := event.User
-	channelID := event.Channel
-	messageTimestamp := event.TimeStamp
+// files are the files attached to the message, if any.
+func HandleAppMentionEvent(client *slack.Client, event *slackevents.AppMentionEvent, files []slack.File) {
+	handleMessage(client, incomingMessage{
+		User:            event.User,
+		Channel:         event.Channel,
+		Timestamp:       event.TimeStamp,
+		ThreadTimestamp: event.ThreadTimeStamp,
+		Text:            event.Text,
+		Files:           files,
+	})
+}
+
+// HandleDirectMessageEvent processes messages sent to BotBot in a DM. Edits,
+// bot messages and BotBot's own replies are ignored so it never talks to itself.
+func HandleDirectMessageEvent(client *slack.Client, event *slackevents.MessageEvent, files []slack.File) {
+	if event.ChannelType != slack.TYPE_IM {
+		return
+	}
+	if event.SubType != "" && event.SubType != "file_share" {
+		return
+	}
+	if event.BotID != "" || event.User == "" || event.User == botID {
+		return
+	}
+	handleMessage(client, incomingMessage{
+		User:            event.User,
+		Channel:         event.Channel,
+		Timestamp:       event.TimeStamp,
+		ThreadTimestamp: event.ThreadTimeStamp,
+		Text:            event.Text,
+		Files:           files,
+		Direct:          true,
+	})
+}
 
-	text := strings.TrimSpace(strings.Replace(event.Text, fmt.Sprintf("<@%s>", botID), "", -1))
+// HandleSlashCommand runs a /botbot command and answers it ephemerally
+// through the command's response URL.
+func HandleSlashCommand(cmd slack.SlashCommand) {
+	text := strings.TrimSpace(cmd.Text)
+	if text == "" {
+		text = "help"
+	}
+
+	origin := Origin{User: cmd.UserID, Channel: cmd.ChannelID, ChannelName: cmd.ChannelName}
+	response, ok := RunCommand(origin, text)
+	if !ok {
+		response = fmt.Sprintf("Unknown command `%s`.\n\n%s", text, helpCommand(origin, ""))
+	}
+
+	err := slack.PostWebhook(cmd.ResponseURL, &slack.WebhookMessage{
+		Text:         response,
+		ResponseType: slack.ResponseTypeEphemeral,
+	})
+	if err != nil {
+		log.Printf("Failed to respond to slash command: %v", err)
+	}
+}
+
+// handleMessage runs a message through the bot and replies to it.
+func handleMessage(client *slack.Client, msg incomingMessage) {
+	userID := msg.User
+	channelID := msg.Channel
+	messageTimestamp := msg.Timestamp
+	origin := Origin{User: userID, Channel: channelID, Timestamp: messageTimestamp}
+
+	// Reply in the thread the message came from. Channel mentions start a new
+	// thread under the mention, DMs are answered inline.
+	threadTimestamp := msg.ThreadTimestamp
+	if threadTimestamp == "" && !msg.Direct {
+		threadTimestamp = messageTimestamp
+	}
+
+	text := strings.TrimSpace(strings.Replace(msg.Text, fmt.Sprintf("<@%s>", botID), "", -1))
 
 	err := client.AddReaction(thinkingEmoji, slack.ItemRef{
 		Channel:   channelID,
@@ -98,49 +252,184 @@ func HandleAppMentionEvent(client *slack.Client, event *slackevents.AppMentionEv
 		}
 	}()
 
-    text = strings.ToLower(text) 
-	// Check if the user sent "ping"
-	if text == "ping" {
-		response := fmt.Sprintf("Hello <@%s>! Pong!", userID)
-		_, _, err := client.PostMessage(channelID, slack.MsgOptionText(response, false))
-		if err != nil {
-			log.Printf("Failed to post message: %v", err)
+	// Thread messages since the bot's last reply aren't in conversation memory
+	// yet. They are stored along with this turn, whatever it turns out to be,
+	// so the next reply only needs the messages after this one.
+	memoryKey := conversationKey(channelID, threadTimestamp)
+	var thread []string
+	if msg.ThreadTimestamp != "" {
+		thread = threadContext(client, channelID, msg.ThreadTimestamp, messageTimestamp)
+	}
+	remember := func(messages ...string) {
+		messages = append(thread, messages...)
+		if len(messages) == 0 {
+			return
+		}
+		if err := memory.Append(memoryKey, messages...); err != nil {
+			log.Printf("Failed to save conversation: %v", err)
 		}
+	}
+
+	// Attached files are saved to Notion, the message text is their labels
+	if len(msg.Files) > 0 {
+		response := SaveFiles(client, msg.Files, splitLabels(strings.Fields(text)), origin)
+		remember()
+		postReply(client, channelID, threadTimestamp, response)
 		return
 	}
 
-	if text == "-h" || text == "-help" {
-		notionDBURL := os.Getenv("NOTION_DB_LINK")
-		helpMessage := fmt.Sprintf("To add a link to notion follow the format:\n`@BotBot YOUR-URL-LINK-HERE LABEL1 LABEL2 ...`\n\nNotion Database URL:\n%s", notionDBURL)
-		_, _, err := client.PostMessage(channelID, slack.MsgOptionText(helpMessage, false))
-		if err != nil {
-			log.Printf("Failed to post message: %v", err)
-		}
+	if response, ok := RunMessageCommand(origin, text); ok {
+		remember()
+		postReply(client, channelID, threadTimestamp, response)
 		return
 	}
 
 	systemMessage := "You are a helpful, funny, and sarcastic Slack bot called BotBot that can answer questions and add links to Notion. When adding links to Notion, users should provide the URL and optional labels.Users should call bot via the -h flag"
 
-	history := conversationHistory[userID]
-	if len(history) == 0 {
-		history = []string{fmt.Sprintf("System: %s", systemMessage)}
+	turns, err := memory.Load(memoryKey)
+	if err != nil {
+		log.Printf("Failed to load conversation: %v", err)
 	}
-	
-	response, err := CallOllama(text, history)
+	history := append([]string{fmt.Sprintf("System: %s", systemMessage)}, turns...)
+	history = append(history, thread...)
+
+	streamer := newSlackStreamer(client, channelID, threadTimestamp)
+	response, err := CallLLM(text, history, origin, streamer.Update)
 	if err != nil {
 		response = "Sorry, I couldn't process that."
+		remember()
 	} else {
-		if len(conversationHistory[userID]) == 0 {
-			conversationHistory[userID] = append([]string{fmt.Sprintf("System: %s", systemMessage)}, fmt.Sprintf("User: %s", text), fmt.Sprintf("Bot: %s", response))
-		} else {
-			conversationHistory[userID] = append(conversationHistory[userID], fmt.Sprintf("User: %s", text), fmt.Sprintf("Bot: %s", response))
-		}
+		remember(fmt.Sprintf("User: %s", text), fmt.Sprintf("Bot: %s", response))
+	}
+	streamer.Finish(response)
+
+	if err := CompactHistory(memoryKey); err != nil {
+		log.Printf("Failed to compact conversation: %v", err)
 	}
 
-	_, _, err = client.PostMessage(channelID, slack.MsgOptionText(response, false))
+	
+}
+
+// conversationKey scopes conversation memory to a single thread, or to the
+// whole channel for messages outside a thread.
+func conversationKey(channelID, threadTimestamp string) string {
+	if threadTimestamp == "" {
+		return channelID
+	}
+	return channelID + ":" + threadTimestamp
+}
+
+// replyOptions builds the message options for text posted in the given
+// thread, or top-level when threadTimestamp is empty.
+func replyOptions(threadTimestamp, text string) []slack.MsgOption {
+	opts := []slack.MsgOption{slack.MsgOptionText(text, false)}
+	if threadTimestamp != "" {
+		opts = append(opts, slack.MsgOptionTS(threadTimestamp))
+	}
+	return opts
+}
+
+// postReply posts text into the given thread.
+func postReply(client *slack.Client, channelID, threadTimestamp, text string) {
+	_, _, err := client.PostMessage(channelID, replyOptions(threadTimestamp, text)...)
 	if err != nil {
 		log.Printf("Failed to post message: %v", err)
 	}
+}
 
-	
-}
\ No newline at end of file
+// threadContext returns the thread messages posted since BotBot last replied
+// in the thread, so a mention in an existing discussion sees what was said.
+// The current message is left out since it is passed separately.
+func threadContext(client *slack.Client, channelID, threadTimestamp, currentTimestamp string) []string {
+	var messages []slack.Message
+	cursor := ""
+	for {
+		replies, hasMore, nextCursor, err := client.GetConversationReplies(&slack.GetConversationRepliesParameters{
+			ChannelID: channelID,
+			Timestamp: threadTimestamp,
+			Cursor:    cursor,
+			Limit:     200,
+		})
+		if err != nil {
+			log.Printf("Failed to fetch thread replies: %v", err)
+			return nil
+		}
+		messages = append(messages, replies...)
+		if !hasMore || nextCursor == "" {
+			break
+		}
+		cursor = nextCursor
+	}
+
+	// Everything up to the bot's last reply was stored in conversation memory
+	// with the turn that reply answered
+	start := 0
+	for i, msg := range messages {
+		if msg.User == botID {
+			start = i + 1
+		}
+	}
+
+	lines := []string{}
+	for _, msg := range messages[start:] {
+		if msg.Timestamp == currentTimestamp || strings.TrimSpace(msg.Text) == "" {
+			continue
+		}
+		text := strings.TrimSpace(strings.Replace(msg.Text, fmt.Sprintf("<@%s>", botID), "", -1))
+		lines = append(lines, fmt.Sprintf("User <@%s>: %s", msg.User, text))
+	}
+	return lines
+}
+
+// slackStreamer posts a placeholder reply and edits it as the answer streams
+// in, at most once per streamUpdateInterval to stay within Slack rate limits.
+type slackStreamer struct {
+	client          *slack.Client
+	channelID       string
+	threadTimestamp string
+	timestamp       string
+	lastUpdate      time.Time
+	lastText        string
+}
+
+func newSlackStreamer(client *slack.Client, channelID, threadTimestamp string) *slackStreamer {
+	s := &slackStreamer{client: client, channelID: channelID, threadTimestamp: threadTimestamp}
+	_, ts, err := client.PostMessage(channelID, replyOptions(threadTimestamp, streamPlaceholder)...)
+	if err != nil {
+		log.Printf("Failed to post placeholder message: %v", err)
+		return s
+	}
+	s.timestamp = ts
+	s.lastUpdate = time.Now()
+	return s
+}
+
+// Update shows the partial answer if enough time has passed since the last edit.
+func (s *slackStreamer) Update(text string) {
+	if s.timestamp == "" || strings.TrimSpace(text) == "" || time.Since(s.lastUpdate) < streamUpdateInterval {
+		return
+	}
+	s.edit(text + " ...")
+}
+
+// Finish replaces the placeholder with the final answer, or posts it as a new
+// message if the placeholder could not be created.
+func (s *slackStreamer) Finish(text string) {
+	if s.timestamp == "" {
+		postReply(s.client, s.channelID, s.threadTimestamp, text)
+		return
+	}
+	s.edit(text)
+}
+
+func (s *slackStreamer) edit(text string) {
+	if text == s.lastText {
+		return
+	}
+	_, _, _, err := s.client.UpdateMessage(s.channelID, s.timestamp, slack.MsgOptionText(text, false))
+	if err != nil {
+		log.Printf("Failed to update message: %v", err)
+	}
+	s.lastUpdate = time.Now()
+	s.lastText = text
+}
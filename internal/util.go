@@ -5,6 +5,10 @@ import (
 
 )
 
+// maxContentChars caps the document text sent to the LLM for summarizing,
+// roughly what the HTML scraper returns, so it fits in the model's context.
+const maxContentChars = 6000
+
 var verbose bool
 
 func SetVerbose(v bool) {
@@ -18,4 +22,12 @@ func PrintDebug(str string){
 		logx.Debug("\n______________________________\n")
 	}
 	
-}
\ No newline at end of file
+}
+
+// truncateText cuts text to at most n runes.
+func truncateText(text string, n int) string {
+	if runes := []rune(text); len(runes) > n {
+		return string(runes[:n])
+	}
+	return text
+}
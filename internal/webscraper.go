@@ -2,73 +2,213 @@ package util
 
 
 import (
+    "bytes"
     "fmt"
-    "net/http"
+    "log"
+    "os"
+    "net/url"
     "strings"
 	"io"
-	"unicode"
+	"math"
+	"regexp"
 
     "github.com/PuerkitoBio/goquery"
+	"golang.org/x/net/html"
 )
 
 
-func scrapeArxiv(url string) (string, string, string, error) {
-	url = strings.TrimSpace(url)
-	url = strings.TrimSuffix(url, ",")
-
-    resp, err := http.Get(url)
+// parseHTML loads an HTML page and extracts it with the extractor registered
+// for its host. pageURL may be nil for pages that didn't come from the web.
+func parseHTML(body io.Reader, pageURL *url.URL) (Document, error) {
+    doc, err := goquery.NewDocumentFromReader(body)
     if err != nil {
-        return "", "", "", fmt.Errorf("error fetching data: %v", err)
+        return Document{}, fmt.Errorf("error loading document: %v", err)
     }
-    defer resp.Body.Close()
+    return extractDocument(doc, pageURL)
+}
 
-    // Check if the response status is OK (200)
-    if resp.StatusCode != http.StatusOK {
-        return "", "", "", fmt.Errorf("error: status code %d", resp.StatusCode)
-    }
+// =============================== Main content extraction =====================
 
-    // Load the HTML document
-    doc, err := goquery.NewDocumentFromReader(resp.Body)
-    if err != nil {
-        return "", "", "", fmt.Errorf("error loading document: %v", err)
-    }
+var (
+	// Class and id hints for page furniture that is never the article.
+	unlikelyCandidateRe = regexp.MustCompile(`(?i)banner|breadcrumb|combx|comment|community|cookie|consent|disqus|extra|foot|header|legends|menu|modal|nav|newsletter|pager|pagination|popup|promo|related|remark|rss|share|shoutbox|sidebar|skyscraper|social|sponsor|subscribe|tags|tool|widget|advert|\bads?\b`)
+	// Hints that an element holds the article even if it looks unlikely.
+	likelyCandidateRe = regexp.MustCompile(`(?i)and|article|body|column|content|main|shadow|entry|post|story|text`)
+	positiveHintRe    = regexp.MustCompile(`(?i)article|body|content|entry|hentry|h-entry|main|page|post|text|blog|story`)
+	negativeHintRe    = regexp.MustCompile(`(?i)hidden|banner|combx|comment|com-|contact|foot|footer|footnote|masthead|media|meta|outbrain|promo|related|scroll|share|shoutbox|sidebar|skyscraper|sponsor|shopping|tags|tool|widget|cookie|nav`)
+)
 
-    // headers := []string{}
-    paragraphs := []string{}
-    abstract := ""
-
-    // Extract headers (h1, h2, h3, h4, h5, h6)
-    // doc.Find("h1").Each(func(i int, s *goquery.Selection) {
-    //     headers = append(headers, s.Text())
-    // })
-	title := doc.Find("title").First().Text()
-    if title == "" {
-        title = doc.Find("h1").First().Text()
-    }
-    if title == "" {
-        title = doc.Find("h2").First().Text()
-    }
+const (
+	// Paragraphs shorter than this don't count towards a container's score.
+	minParagraphChars = 25
+	// Blocks where more than this share of the text is links are navigation.
+	maxLinkDensity = 0.5
+)
+
+// mainContent finds the element holding the page's main text, scoring
+// containers by how much paragraph text they hold and penalizing link-heavy
+// ones, in the spirit of Readability. Headings and lists are kept as markdown
+// "#" lines and "-" bullets. It returns "" when no container stands out.
+func mainContent(doc *goquery.Document) string {
+	body := doc.Find("body").First().Clone()
+	if body.Length() == 0 {
+		return ""
+	}
+
+	body.Find("script, style, noscript, nav, header, footer, aside, form, iframe, svg, button, select, template").Remove()
+	body.Find("*").Each(func(i int, s *goquery.Selection) {
+		if goquery.NodeName(s) == "body" || goquery.NodeName(s) == "article" || goquery.NodeName(s) == "main" {
+			return
+		}
+		hints := s.AttrOr("class", "") + " " + s.AttrOr("id", "")
+		if unlikelyCandidateRe.MatchString(hints) && !likelyCandidateRe.MatchString(hints) {
+			s.Remove()
+		}
+	})
 
-    // Extract paragraphs
-    doc.Find("p").EachWithBreak(func(i int, s *goquery.Selection) bool {
-        paragraphs = append(paragraphs, s.Text())
-        return i < 9 // Stop after 10 paragraphs (0-9)
-    })
+	scores := map[*html.Node]float64{}
+	var candidates []*goquery.Selection
+	addScore := func(s *goquery.Selection, score float64) {
+		if s.Length() == 0 {
+			return
+		}
+		node := s.Get(0)
+		if _, ok := scores[node]; !ok {
+			scores[node] = initialScore(s)
+			candidates = append(candidates, s)
+		}
+		scores[node] += score
+	}
 
-    // Extract the abstract specifically
-    doc.Find("blockquote.abstract").Each(func(i int, s *goquery.Selection) {
-        abstract = s.Text()
-    })
+	body.Find("p, pre, td, blockquote").Each(func(i int, s *goquery.Selection) {
+		text := cleanText(s.Text())
+		if len(text) < minParagraphChars {
+			return
+		}
+		// One point per paragraph, per comma and per 100 characters, up to 3
+		score := 1 + float64(strings.Count(text, ",")) + math.Min(float64(len(text))/100, 3)
+		addScore(s.Parent(), score)
+		addScore(s.Parent().Parent(), score/2)
+	})
+
+	var top *goquery.Selection
+	topScore := 0.0
+	for _, s := range candidates {
+		score := scores[s.Get(0)] * (1 - linkDensity(s))
+		scores[s.Get(0)] = score
+		if score > topScore {
+			top, topScore = s, score
+		}
+	}
+	if top == nil {
+		return ""
+	}
 
-    // combinedHeaders := strings.Join(headers, "\n\n")
-    combinedParagraphs := strings.Join(paragraphs, "\n\n")
+	// Articles are sometimes split across sibling containers, so keep the
+	// siblings that score well too.
+	threshold := math.Max(10, topScore*0.2)
+	parts := []string{}
+	top.Parent().Children().Each(func(i int, s *goquery.Selection) {
+		if s.Get(0) == top.Get(0) || scores[s.Get(0)] >= threshold || isContentParagraph(s) {
+			if text := renderContent(s); text != "" {
+				parts = append(parts, text)
+			}
+		}
+	})
+	return strings.Join(parts, "\n")
+}
 
-	PrintDebug(strings.TrimSpace(abstract))
-	title = strings.TrimLeftFunc(title, func(r rune) bool {
-        return !unicode.IsLetter(r)
-    })
+// initialScore weighs a container by its tag and its class and id hints.
+func initialScore(s *goquery.Selection) float64 {
+	score := 0.0
+	switch goquery.NodeName(s) {
+	case "article", "main":
+		score += 10
+	case "div", "section":
+		score += 5
+	case "pre", "td", "blockquote":
+		score += 3
+	case "address", "ol", "ul", "dl", "dd", "dt", "li", "form":
+		score -= 3
+	case "h1", "h2", "h3", "h4", "h5", "h6", "th":
+		score -= 5
+	}
 
-    return title, strings.TrimSpace(abstract), combinedParagraphs, nil
+	hints := s.AttrOr("class", "") + " " + s.AttrOr("id", "")
+	if negativeHintRe.MatchString(hints) {
+		score -= 25
+	}
+	if positiveHintRe.MatchString(hints) {
+		score += 25
+	}
+	return score
+}
+
+// linkDensity is the share of an element's text that sits inside links.
+func linkDensity(s *goquery.Selection) float64 {
+	textLength := len(cleanText(s.Text()))
+	if textLength == 0 {
+		return 0
+	}
+	linkLength := 0
+	s.Find("a").Each(func(i int, a *goquery.Selection) {
+		linkLength += len(cleanText(a.Text()))
+	})
+	return float64(linkLength) / float64(textLength)
+}
+
+// isContentParagraph reports whether a lone sibling paragraph reads like part
+// of the article rather than a caption or a link.
+func isContentParagraph(s *goquery.Selection) bool {
+	if goquery.NodeName(s) != "p" {
+		return false
+	}
+	text := cleanText(s.Text())
+	density := linkDensity(s)
+	return (len(text) > 80 && density < 0.25) || (len(text) > 0 && density == 0 && strings.ContainsAny(text, ".!?"))
+}
+
+// renderContent turns an element into text, one line per block, skipping
+// blocks that are mostly links.
+func renderContent(s *goquery.Selection) string {
+	lines := []string{}
+	var walk func(*goquery.Selection)
+	walk = func(s *goquery.Selection) {
+		name := goquery.NodeName(s)
+		switch name {
+		case "h1", "h2", "h3", "h4", "h5", "h6":
+			if text := cleanText(s.Text()); text != "" {
+				lines = append(lines, strings.Repeat("#", int(name[1]-'0'))+" "+text)
+			}
+		case "p", "pre", "blockquote", "li", "dt", "dd", "figcaption":
+			text := cleanText(s.Text())
+			if text == "" || linkDensity(s) > maxLinkDensity {
+				return
+			}
+			switch name {
+			case "li":
+				text = "- " + text
+			case "blockquote":
+				text = "> " + text
+			}
+			lines = append(lines, text)
+		case "img", "figure", "picture", "video", "audio", "table":
+			if name == "figure" {
+				s.Find("figcaption").Each(func(i int, c *goquery.Selection) { walk(c) })
+			}
+		default:
+			// Text placed directly in a container, e.g. divs with <br>s
+			direct := cleanText(s.Contents().FilterFunction(func(i int, c *goquery.Selection) bool {
+				return c.Get(0).Type == html.TextNode
+			}).Text())
+			if len(direct) >= minParagraphChars {
+				lines = append(lines, direct)
+			}
+			s.Children().Each(func(i int, c *goquery.Selection) { walk(c) })
+		}
+	}
+	walk(s)
+	return strings.Join(lines, "\n")
 }
 
 func stringDiff(a, b string) string {
@@ -87,38 +227,199 @@ func stringDiff(a, b string) string {
     return diff.String()
 }
 
-// WebScraper function (to be implemented)
+// WebScraper fetches a link and returns its title and the text to summarize:
+// the abstract or main text of HTML pages, or the first pages of PDFs.
 func WebScraper(url string) (string, string, error) {
-	PrintDebug("Input URL: "+ url)
-	title, abstract, paragraph, err := scrapeArxiv(url)
-	if err != nil{
-		return "", "", fmt.Errorf("error scraping arXiv: %w", err)
+	doc, err := ScrapeDocument(url)
+	if err != nil {
+		return "", "", err
+	}
+	return doc.Title, doc.Content(), nil
+}
+
+// ScrapeDocument fetches a link and extracts its structured content. Sites
+// with an API are asked first, then each configured content source is tried
+// in turn until one returns enough text. The source used is recorded in
+// Document.Source.
+func ScrapeDocument(link string) (Document, error) {
+	PrintDebug("Input URL: "+ link)
+	link = strings.TrimSpace(link)
+	link = strings.TrimSuffix(link, ",")
+
+	pageURL, err := url.Parse(link)
+	if err != nil {
+		return Document{}, fmt.Errorf("invalid URL: %w", err)
+	}
+
+	// Respecting robots.txt covers every source, not just the direct fetch
+	if !fetchClient.Allowed(pageURL) {
+		return Document{}, fmt.Errorf("error scraping %s: %w", link, errRobotsDisallowed)
+	}
+
+	if fetcher, ok := extractorFor(pageURL.Hostname()).(Fetcher); ok {
+		doc, err := fetcher.Fetch(pageURL)
+		if err == nil {
+			doc.Source = "api"
+			return doc, nil
+		}
+		log.Printf("Falling back to scraping %s: %v", link, err)
 	}
-	if len(abstract) == 0{
-		return title, paragraph, nil
+
+	// Keep the best short result in case no source does better
+	var best Document
+	var errs []string
+	for _, name := range scrapeSources {
+		source, ok := contentSources[name]
+		if !ok {
+			errs = append(errs, fmt.Sprintf("%s: unknown source", name))
+			continue
+		}
+
+		doc, err := source(link, pageURL)
+		if err != nil {
+			log.Printf("Source %s failed for %s: %v", name, link, err)
+			errs = append(errs, fmt.Sprintf("%s: %v", name, err))
+			continue
+		}
+		doc.Source = name
+		doc.Body = truncateText(doc.Body, maxContentChars)
+		PrintDebug(fmt.Sprintf("Scraped %q from %s: %d authors, abstract %d chars, body %d chars",
+			doc.Title, name, len(doc.Authors), len(doc.Abstract), len(doc.Body)))
+
+		if len([]rune(doc.Content())) >= minContentChars {
+			return doc, nil
+		}
+		log.Printf("Source %s returned too little text for %s", name, link)
+		if len(doc.Content()) > len(best.Content()) {
+			best = doc
+		}
+	}
+
+	if best.Source != "" {
+		return best, nil
 	}
-	return title, abstract, nil
+	return Document{}, fmt.Errorf("error scraping %s: %s", link, strings.Join(errs, "; "))
 }
 
-func jinaScrapper(url string) (string, error) {
+// =============================== Content sources =============================
+
+// contentSource fetches a link through one route and extracts its content.
+type contentSource func(link string, pageURL *url.URL) (Document, error)
+
+const (
+	// Below this many characters a source is assumed to have hit a paywall,
+	// a bot check or a JavaScript-only page, and the next one is tried.
+	minContentChars = 200
+
+	defaultScrapeSources = "direct,jina,wayback"
+	defaultJinaReaderURL = "https://r.jina.ai/"
+)
 
-	fullURL := "https://r.jina.ai/" + url
+var (
+	contentSources = map[string]contentSource{
+		"direct":  fetchDirect,
+		"jina":    fetchJina,
+		"wayback": fetchWayback,
+	}
+
+	scrapeSources = strings.Split(defaultScrapeSources, ",")
+	jinaReaderURL = defaultJinaReaderURL
+)
+
+// InitScraper reads the content source chain from SCRAPE_SOURCES, a comma
+// separated list of "direct", "jina" and "wayback", and the Jina reader
+// endpoint from JINA_READER_URL, which can point at a self-hosted instance.
+func InitScraper() {
+	if v := os.Getenv("SCRAPE_SOURCES"); v != "" {
+		scrapeSources = nil
+		for _, name := range strings.Split(v, ",") {
+			name = strings.ToLower(strings.TrimSpace(name))
+			if _, ok := contentSources[name]; !ok {
+				log.Fatalf("Unknown scrape source %q in SCRAPE_SOURCES", name)
+			}
+			scrapeSources = append(scrapeSources, name)
+		}
+	}
+	if v := os.Getenv("JINA_READER_URL"); v != "" {
+		jinaReaderURL = v
+	}
+	if !strings.HasSuffix(jinaReaderURL, "/") {
+		jinaReaderURL += "/"
+	}
+}
+
+// fetchDirect downloads the page itself.
+func fetchDirect(link string, pageURL *url.URL) (Document, error) {
+	return fetchPage(link, pageURL)
+}
 
-	response, err := http.Get(fullURL)
+// fetchWayback reads the latest Internet Archive snapshot of the page. The
+// "id_" suffix asks for the original page without the archive's toolbar.
+func fetchWayback(link string, pageURL *url.URL) (Document, error) {
+	return fetchPage("https://web.archive.org/web/2id_/"+link, pageURL)
+}
+
+// fetchPage downloads fetchURL and extracts it as the page at pageURL.
+func fetchPage(fetchURL string, pageURL *url.URL) (Document, error) {
+	resp, err := fetchClient.Get(fetchURL)
 	if err != nil {
-		return "", fmt.Errorf("failed to make request: %w", err)
+		return Document{}, fmt.Errorf("error fetching data: %w", err)
+	}
+
+	if isPDF(resp.Header.Get("Content-Type"), pageURL.String()) {
+		title, text, err := readPDF(bytes.NewReader(resp.Body))
+		if err != nil {
+			return Document{}, fmt.Errorf("error scraping PDF: %w", err)
+		}
+		return Document{Title: title, Body: text}, nil
 	}
-	defer response.Body.Close()
 
-	if response.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("unexpected status code: %d", response.StatusCode)
+	doc, err := parseHTML(bytes.NewReader(resp.Body), pageURL)
+	if err != nil {
+		return Document{}, fmt.Errorf("error scraping page: %w", err)
 	}
+	return doc, nil
+}
 
-	body, err := io.ReadAll(response.Body)
+// fetchJina reads the page through the Jina reader, which renders it in a
+// browser and returns markdown.
+func fetchJina(link string, pageURL *url.URL) (Document, error) {
+	body, err := jinaScrapper(link)
 	if err != nil {
-		return "", fmt.Errorf("failed to read response body: %w", err)
+		return Document{}, err
+	}
+	return parseJinaResponse(body), nil
+}
+
+// parseJinaResponse reads the reader's "Title: ...", "URL Source: ...",
+// "Markdown Content:" text format.
+func parseJinaResponse(body string) Document {
+	doc := Document{}
+	header, content, found := strings.Cut(body, "Markdown Content:")
+	if !found {
+		header, content = "", body
+	}
+	for _, line := range strings.Split(header, "\n") {
+		if title, ok := strings.CutPrefix(strings.TrimSpace(line), "Title:"); ok {
+			doc.Title = strings.TrimSpace(title)
+		}
+		if date, ok := strings.CutPrefix(strings.TrimSpace(line), "Published Time:"); ok {
+			doc.Date = strings.TrimSpace(date)
+		}
+	}
+	doc.Body = strings.TrimSpace(content)
+	return doc
+}
+
+func jinaScrapper(url string) (string, error) {
+
+	fullURL := jinaReaderURL + url
+
+	response, err := fetchClient.Get(fullURL)
+	if err != nil {
+		return "", fmt.Errorf("failed to make request: %w", err)
 	}
 
-	PrintDebug("jina response: " + string(body))
-	return string(body), nil
+	PrintDebug("jina response: " + string(response.Body))
+	return string(response.Body), nil
 }
\ No newline at end of file
@@ -0,0 +1,113 @@
+package util
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+func loadHTMLFixture(t *testing.T, name string) *goquery.Document {
+	t.Helper()
+	f, err := os.Open(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	doc, err := goquery.NewDocumentFromReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return doc
+}
+
+func TestMainContent(t *testing.T) {
+	tests := []struct {
+		file        string
+		want        []string // lines, in order
+		boilerplate []string
+	}{
+		{
+			file: "blog-article.html",
+			want: []string{
+				"# Understanding Attention",
+				"By Ada Example, March 3, 2024",
+				"Attention lets a model weigh every token of its input when it produces each output, instead of squeezing the whole sequence into one fixed vector.",
+				"In this post we walk through scaled dot-product attention, multi-head attention and the tricks that make them fast, with small examples along the way.",
+				"## Scaled dot-product attention",
+				"Queries are compared with keys, the scores are scaled by the square root of the key size, and a softmax turns them into weights for the values.",
+				"- Queries come from the position being computed.",
+				"- Keys and values come from the positions being attended to.",
+				"## Making it fast",
+				"- Fuse the softmax with the matrix products.",
+				"- Tile the computation so it fits in on-chip memory.",
+				"> Attention is a soft, differentiable dictionary lookup, and most of its variants change how the lookup is done.",
+				"With these pieces in place, the rest of the transformer is mostly bookkeeping around residual connections and normalization.",
+			},
+			boilerplate: []string{"cookies", "Archive", "Comments", "Great post", "Popular posts", "Ten tricks", "newsletter", "Copyright", "analytics", "font-family"},
+		},
+		{
+			file: "news-divs.html",
+			want: []string{
+				"# City council approves new bike lanes",
+				"The city council voted seven to two on Tuesday to add protected bike lanes along Main Street, ending a debate that lasted more than a year.",
+				"Supporters said the lanes would make the busy corridor safer, while shop owners worried about losing parking in front of their stores.",
+				"## What changes",
+				"- Two kilometres of protected lanes on Main Street",
+				"- New crossings at Oak Avenue and Third Street",
+				"Construction is expected to start in the spring and to take about six months, according to the transport department.",
+			},
+			boilerplate: []string{"Sports", "mattress", "Council delays vote", "Residents divided", "Share this story", "newsroom"},
+		},
+		{
+			// Nothing but links, no container stands out
+			file: "link-list.html",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			got := mainContent(loadHTMLFixture(t, tt.file))
+			if want := strings.Join(tt.want, "\n"); got != want {
+				t.Errorf("mainContent() =\n%s\n\nwant:\n%s", got, want)
+			}
+			for _, text := range tt.boilerplate {
+				if strings.Contains(got, text) {
+					t.Errorf("mainContent() kept boilerplate %q", text)
+				}
+			}
+		})
+	}
+}
+
+func TestParseHTMLFixture(t *testing.T) {
+	f, err := os.Open(filepath.Join("testdata", "blog-article.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	doc, err := parseHTML(f, nil)
+	if err != nil {
+		t.Fatalf("parseHTML: %v", err)
+	}
+	if doc.Title != "Understanding Attention" {
+		t.Errorf("Title = %q, want %q", doc.Title, "Understanding Attention")
+	}
+	if !strings.HasPrefix(doc.Body, "# Understanding Attention\n") || strings.Contains(doc.Body, "Popular posts") {
+		t.Errorf("Body is not the article:\n%s", doc.Body)
+	}
+}
+
+func TestParseJinaResponse(t *testing.T) {
+	body := "Title: Understanding Attention\nURL Source: https://example.com/attention\nPublished Time: 2024-03-03\n\nMarkdown Content:\n# Understanding Attention\n\nAttention lets a model weigh every token.\n"
+	doc := parseJinaResponse(body)
+	if doc.Title != "Understanding Attention" || doc.Date != "2024-03-03" {
+		t.Errorf("Title, Date = %q, %q", doc.Title, doc.Date)
+	}
+	if want := "# Understanding Attention\n\nAttention lets a model weigh every token."; doc.Body != want {
+		t.Errorf("Body = %q, want %q", doc.Body, want)
+	}
+}